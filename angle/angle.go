@@ -18,7 +18,9 @@ Package angle contains utility routines for computing angles.
 package angle
 
 import (
+	"fmt"
 	"math"
+	"sort"
 )
 
 const (
@@ -39,6 +41,72 @@ func AddDeg(θ1, θ2 float64) float64 {
 	return math.Mod(θ1+θ2, 360)
 }
 
+/*
+Return the mean direction and the mean resultant length of angles in radians.
+mean is in [0,2π) and R is in [0,1], where 1 means all angles are equal and 0 that the angles
+have no mean direction, in which case mean is 0. Centroid returns 0, 0 for empty angles.
+*/
+func Centroid(angles []float64) (mean, R float64) {
+	if len(angles) == 0 {
+		return 0, 0
+	}
+	var sin, cos float64
+	for _, θ := range angles {
+		sin += math.Sin(θ)
+		cos += math.Cos(θ)
+	}
+	n := float64(len(angles))
+	R = math.Hypot(sin, cos) / n
+	if R < FP_IGNORE {
+		return 0, 0
+	}
+	return FromSlope(cos, sin), math.Min(R, 1)
+}
+
+/*
+Return the mean direction in degrees [0,360) and the mean resultant length of angles in degrees.
+See Centroid for details.
+*/
+func CentroidDeg(angles []float64) (mean, R float64) {
+	rads := make([]float64, len(angles))
+	for i, θ := range angles {
+		rads[i] = ToRad(θ)
+	}
+	mean, R = Centroid(rads)
+	return ToDeg(mean), R
+}
+
+/*
+Return θ in radians saturated into the arc between lo and hi. The arc is the shorter of the two
+arcs with ends lo and hi. If θ is on the arc it is returned unchanged, otherwise the nearer of
+lo and hi is returned. If θ is equidistant from lo and hi, i.e. opposite the middle of the arc,
+lo is returned.
+*/
+func ClampToArc(θ, lo, hi float64) float64 {
+	return clampToArc(θ, lo, hi, 2*math.Pi)
+}
+
+/*
+Return θ in degrees saturated into the arc between lo and hi. See ClampToArc for details.
+*/
+func ClampToArcDeg(θ, lo, hi float64) float64 {
+	return clampToArc(θ, lo, hi, 360)
+}
+
+func clampToArc(θ, lo, hi, period float64) float64 {
+	from, span := lo, normalize(hi-lo, period)
+	if span > period/2 {
+		from, span = hi, period-span
+	}
+	if normalize(θ-from, period) <= span {
+		return θ
+	}
+	if math.Abs(signedDiff(θ, hi, period)) < math.Abs(signedDiff(θ, lo, period)) {
+		return hi
+	}
+	return lo
+}
+
 /*
 Returns cosine similarity between unit direction vectors. θ1 and θ2 are in [0,2π) or (-π,π).
 The result is in [0,1], where 0 means the vectors are orthogonal and 1 that θ1 = θ2 or θ1 = -θ2.
@@ -71,11 +139,39 @@ func Diff(θ1, θ2 float64) float64 {
 	return θ
 }
 
+/*
+Return the smaller angle in [0,180] between θ1 and θ2 in degrees, e.g.: DiffDeg(350, 10) is 20.
+*/
+func DiffDeg(θ1, θ2 float64) float64 {
+	θ := normalize(θ1-θ2, 360)
+	if θ > 180 {
+		θ = 360 - θ
+	}
+	return θ
+}
+
 func Equal(θ1, θ2 float64) bool {
 	d := Diff(θ1, θ2)
 	return d < FP_IGNORE
 }
 
+/*
+Return the direction in [0,2π) of the displacement (dx, dy), i.e.: atan2(dy, dx).
+Vertical displacements (dx = 0) return π/2 for dy > 0 and 3π/2 for dy < 0.
+FromSlope(0, 0) returns 0.
+*/
+func FromSlope(dx, dy float64) float64 {
+	θ := math.Atan2(dy, dx)
+	if θ < 0 {
+		θ += 2 * math.Pi
+	}
+	if θ >= 2*math.Pi {
+		// θ was a tiny negative angle
+		θ = 0
+	}
+	return θ
+}
+
 /*
 Invert angle θ by turning it by π radians.
 */
@@ -90,6 +186,163 @@ func InvertDeg(θ float64) float64 {
 	return math.Mod(θ+180, 360)
 }
 
+/*
+Return the mean direction in [0,2π) of angles in radians. See Centroid.
+*/
+func Mean(angles []float64) float64 {
+	mean, _ := Centroid(angles)
+	return mean
+}
+
+/*
+Return the mean direction in [0,360) of angles in degrees. See Centroid.
+*/
+func MeanDeg(angles []float64) float64 {
+	mean, _ := CentroidDeg(angles)
+	return mean
+}
+
+/*
+Return the direction in [0,2π) and the magnitude of the sum of the vectors with directions
+angles in radians and lengths magnitudes. direction is 0 if the magnitude of the sum is
+negligible. Resultant returns an error if angles and magnitudes have different lengths.
+*/
+func Resultant(angles, magnitudes []float64) (direction, magnitude float64, err error) {
+	if len(angles) != len(magnitudes) {
+		return 0, 0, fmt.Errorf("%d angles and %d magnitudes", len(angles), len(magnitudes))
+	}
+	var x, y float64
+	for i, θ := range angles {
+		x += magnitudes[i] * math.Cos(θ)
+		y += magnitudes[i] * math.Sin(θ)
+	}
+	magnitude = math.Hypot(x, y)
+	if magnitude < FP_IGNORE {
+		return 0, magnitude, nil
+	}
+	return FromSlope(x, y), magnitude, nil
+}
+
+/*
+Return the direction in [0,360) and the magnitude of the sum of the vectors with directions
+angles in degrees and lengths magnitudes. See Resultant for details.
+*/
+func ResultantDeg(angles, magnitudes []float64) (direction, magnitude float64, err error) {
+	rads := make([]float64, len(angles))
+	for i, θ := range angles {
+		rads[i] = ToRad(θ)
+	}
+	direction, magnitude, err = Resultant(rads, magnitudes)
+	return ToDeg(direction), magnitude, err
+}
+
+/*
+Return the mean resultant length in [0,1] of angles in radians. See Centroid.
+*/
+func ResultantLength(angles []float64) float64 {
+	_, R := Centroid(angles)
+	return R
+}
+
+/*
+Return the signed angular velocities between consecutive samples of angles in radians at times.
+Element i of the result is SignedDiff(angles[i], angles[i+1]) / (times[i+1] - times[i]).
+Rates returns an error if angles and times have different lengths or if times are not
+strictly increasing.
+*/
+func Rates(angles []float64, times []float64) ([]float64, error) {
+	return rates(angles, times, SignedDiff)
+}
+
+/*
+Return the signed angular velocities of angles in degrees at times.
+See Rates for details.
+*/
+func RatesDeg(angles []float64, times []float64) ([]float64, error) {
+	return rates(angles, times, SignedDiffDeg)
+}
+
+func rates(angles []float64, times []float64, diff func(θ1, θ2 float64) float64) ([]float64, error) {
+	if len(angles) != len(times) {
+		return nil, fmt.Errorf("%d angles and %d times", len(angles), len(times))
+	}
+	if len(angles) < 2 {
+		return []float64{}, nil
+	}
+	rates := make([]float64, len(angles)-1)
+	for i := range rates {
+		dt := times[i+1] - times[i]
+		if dt <= 0 {
+			return nil, fmt.Errorf("times not increasing at index %d: %f, %f", i+1, times[i], times[i+1])
+		}
+		rates[i] = diff(angles[i], angles[i+1]) / dt
+	}
+	return rates, nil
+}
+
+/*
+Return the signed smaller angle in (-π,π] radians to turn from θ1 to θ2.
+The result is positive if the turn is counter-clockwise.
+*/
+func SignedDiff(θ1, θ2 float64) float64 {
+	return signedDiff(θ1, θ2, 2*math.Pi)
+}
+
+/*
+Return the signed smaller angle in (-180,180] degrees to turn from θ1 to θ2.
+See SignedDiff for details.
+*/
+func SignedDiffDeg(θ1, θ2 float64) float64 {
+	return signedDiff(θ1, θ2, 360)
+}
+
+func signedDiff(θ1, θ2, period float64) float64 {
+	d := normalize(θ2-θ1, period)
+	if d > period/2 {
+		d -= period
+	}
+	return d
+}
+
+/*
+Return the slope tan(θ) of a line in direction θ radians.
+Slope returns +Inf for θ = π/2 and -Inf for θ = -π/2 (3π/2), where tan(θ) is undefined.
+*/
+func Slope(θ float64) float64 {
+	switch θ = normalize(θ, 2*math.Pi); {
+	case Equal(θ, math.Pi/2):
+		return math.Inf(1)
+	case Equal(θ, 3*math.Pi/2):
+		return math.Inf(-1)
+	}
+	return math.Tan(θ)
+}
+
+/*
+Return a new slice of angles in radians sorted counter-clockwise from the reference direction
+ref, i.e. ordered by (θ - ref) normalized to [0, 2π). The angles are not modified.
+*/
+func SortFrom(angles []float64, ref float64) []float64 {
+	return sortFrom(angles, ref, 2*math.Pi)
+}
+
+/*
+Return a new slice of angles in degrees sorted counter-clockwise from the reference direction
+ref, i.e. ordered by (θ - ref) normalized to [0, 360).
+*/
+func SortFromDeg(angles []float64, ref float64) []float64 {
+	return sortFrom(angles, ref, 360)
+}
+
+func sortFrom(angles []float64, ref, period float64) []float64 {
+	sorted := make([]float64, len(angles))
+	copy(sorted, angles)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return normalize(sorted[i]-ref, period) < normalize(sorted[j]-ref, period)
+	})
+	return sorted
+}
+
 /*
 Convert degrees to radians.
 */
@@ -103,3 +356,45 @@ Convert radians to degrees.
 func ToDeg(rad float64) float64 {
 	return rad * 180 / math.Pi
 }
+
+/*
+Return the unwrapped sequence of angles in radians. Unwrap adds or subtracts multiples of 2π
+to angles so that the difference between consecutive elements of the result is in (-π, π].
+The first element is unchanged.
+*/
+func Unwrap(angles []float64) []float64 {
+	return unwrap(angles, 2*math.Pi)
+}
+
+/*
+Return the unwrapped sequence of angles in degrees.
+See Unwrap for details.
+*/
+func UnwrapDeg(angles []float64) []float64 {
+	return unwrap(angles, 360)
+}
+
+// normalize returns θ mod period in [0, period)
+func normalize(θ, period float64) float64 {
+	θ = math.Mod(θ, period)
+	if θ < 0 {
+		θ += period
+	}
+	return θ
+}
+
+func unwrap(angles []float64, period float64) []float64 {
+	unwrapped := make([]float64, len(angles))
+	for i, θ := range angles {
+		if i == 0 {
+			unwrapped[i] = θ
+			continue
+		}
+		d := math.Mod(θ-angles[i-1]+period/2, period)
+		if d <= 0 {
+			d += period
+		}
+		unwrapped[i] = unwrapped[i-1] + d - period/2
+	}
+	return unwrapped
+}
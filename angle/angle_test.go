@@ -15,7 +15,12 @@
 package angle
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -94,3 +99,343 @@ func Test6(t *testing.T) {
 		}
 	}
 }
+
+func TestUnwrapDeg(t *testing.T) {
+	var wrapped []float64
+	for d := 0.; d < 1000; d += 50 {
+		wrapped = append(wrapped, math.Mod(d, 360))
+	}
+	unwrapped := UnwrapDeg(wrapped)
+	for i, d := range unwrapped {
+		if math.Abs(d-float64(i)*50) > FP_IGNORE {
+			t.Errorf("unwrapped[%d] = %f, expected %f", i, d, float64(i)*50)
+		}
+	}
+
+	wrapped = wrapped[:0]
+	for d := 1000.; d > 0; d -= 70 {
+		wrapped = append(wrapped, math.Mod(d, 360)-180)
+	}
+	unwrapped = UnwrapDeg(wrapped)
+	for i := 1; i < len(unwrapped); i++ {
+		if math.Abs(unwrapped[i-1]-unwrapped[i]-70) > FP_IGNORE {
+			t.Errorf("unwrapped[%d] = %f, unwrapped[%d] = %f", i-1, unwrapped[i-1], i, unwrapped[i])
+		}
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	wrapped := []float64{6, 0.1, 6.2, 0.3, 0.2}
+	unwrapped := Unwrap(wrapped)
+	for i := range wrapped {
+		if !Equal(math.Mod(unwrapped[i]-wrapped[i], 2*math.Pi), 0) {
+			t.Errorf("unwrapped[%d] = %f is not equivalent to %f", i, unwrapped[i], wrapped[i])
+		}
+		if i > 0 {
+			if d := unwrapped[i] - unwrapped[i-1]; d <= -math.Pi || d > math.Pi {
+				t.Errorf("unwrapped[%d] - unwrapped[%d] = %f", i, i-1, d)
+			}
+		}
+	}
+	if len(Unwrap(nil)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFromSlope(t *testing.T) {
+	tests := []struct {
+		dx, dy, θ float64
+	}{
+		{1, 1, math.Pi / 4},
+		{-1, 1, 3 * math.Pi / 4},
+		{-1, -1, 5 * math.Pi / 4},
+		{1, -1, 7 * math.Pi / 4},
+		{0, 2, math.Pi / 2},
+		{0, -2, 3 * math.Pi / 2},
+		{3, 0, 0},
+		{-3, 0, math.Pi},
+	}
+	for _, test := range tests {
+		if θ := FromSlope(test.dx, test.dy); math.Abs(θ-test.θ) > FP_IGNORE {
+			t.Errorf("FromSlope(%f, %f) = %f, expected %f", test.dx, test.dy, θ, test.θ)
+		}
+	}
+}
+
+func TestSlope(t *testing.T) {
+	if s := Slope(math.Pi / 2); !math.IsInf(s, 1) {
+		t.Errorf("Slope(π/2) = %f", s)
+	}
+	if s := Slope(-math.Pi / 2); !math.IsInf(s, -1) {
+		t.Errorf("Slope(-π/2) = %f", s)
+	}
+	if s := Slope(3 * math.Pi / 2); !math.IsInf(s, -1) {
+		t.Errorf("Slope(3π/2) = %f", s)
+	}
+	for _, θ := range []float64{0, math.Pi} {
+		if s := Slope(θ); math.Abs(s) > FP_IGNORE {
+			t.Errorf("Slope(%f) = %f", θ, s)
+		}
+	}
+	for _, θ := range []float64{math.Pi / 4, 5 * math.Pi / 4} {
+		if s := Slope(θ); math.Abs(s-1) > FP_IGNORE {
+			t.Errorf("Slope(%f) = %f", θ, s)
+		}
+	}
+	if s := Slope(FromSlope(2, -6)); math.Abs(s+3) > FP_IGNORE {
+		t.Errorf("Slope(FromSlope(2, -6)) = %f", s)
+	}
+}
+
+func TestCentroid(t *testing.T) {
+	tests := []struct {
+		angles  []float64
+		mean, R float64
+	}{
+		{[]float64{350, 10}, 0, math.Cos(ToRad(10))},
+		{[]float64{80, 90, 100}, 90, (1 + 2*math.Cos(ToRad(10))) / 3},
+		{[]float64{270, 270}, 270, 1},
+		{[]float64{0, 180}, 0, 0},
+		{nil, 0, 0},
+	}
+	for _, test := range tests {
+		mean, R := CentroidDeg(test.angles)
+		if !Equal(ToRad(mean), ToRad(test.mean)) || math.Abs(R-test.R) > FP_IGNORE {
+			t.Errorf("CentroidDeg(%v) = %f, %f, expected %f, %f", test.angles, mean, R, test.mean, test.R)
+		}
+		if m := MeanDeg(test.angles); m != mean {
+			t.Errorf("MeanDeg(%v) = %f, expected %f", test.angles, m, mean)
+		}
+		rads := make([]float64, len(test.angles))
+		for i, θ := range test.angles {
+			rads[i] = ToRad(θ)
+		}
+		mean, R = Centroid(rads)
+		if m := Mean(rads); m != mean {
+			t.Errorf("Mean(%v) = %f, expected %f", rads, m, mean)
+		}
+		if r := ResultantLength(rads); r != R {
+			t.Errorf("ResultantLength(%v) = %f, expected %f", rads, r, R)
+		}
+		if !Equal(mean, ToRad(test.mean)) || math.Abs(R-test.R) > FP_IGNORE {
+			t.Errorf("Centroid(%v) = %f, %f", rads, mean, R)
+		}
+	}
+}
+
+func TestSignedDiffDeg(t *testing.T) {
+	tests := []struct{ θ1, θ2, d float64 }{
+		{10, 20, 10},
+		{20, 10, -10},
+		{359, 1, 2},
+		{1, 359, -2},
+		{0, 180, 180},
+		{90, -90, 180},
+	}
+	for _, test := range tests {
+		if d := SignedDiffDeg(test.θ1, test.θ2); math.Abs(d-test.d) > FP_IGNORE {
+			t.Errorf("SignedDiffDeg(%f, %f) = %f, expected %f", test.θ1, test.θ2, d, test.d)
+		}
+		if d := SignedDiff(ToRad(test.θ1), ToRad(test.θ2)); math.Abs(d-ToRad(test.d)) > FP_IGNORE {
+			t.Errorf("SignedDiff(%f, %f) = %f", test.θ1, test.θ2, ToDeg(d))
+		}
+	}
+}
+
+func TestRatesDeg(t *testing.T) {
+	rates, err := RatesDeg([]float64{350, 355, 359, 1, 11}, []float64{0, 1, 2, 3, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []float64{5, 4, 2, 5}
+	for i, r := range rates {
+		if math.Abs(r-exp[i]) > FP_IGNORE {
+			t.Errorf("rates[%d] = %f, expected %f", i, r, exp[i])
+		}
+	}
+	rates, err = Rates([]float64{ToRad(1), ToRad(359)}, []float64{0, 0.5})
+	if err != nil || len(rates) != 1 || math.Abs(rates[0]-ToRad(-4)) > FP_IGNORE {
+		t.Errorf("Rates = %v, %v", rates, err)
+	}
+	if _, err := RatesDeg([]float64{1, 2}, []float64{1}); err == nil {
+		t.Error("expected error for mismatched lengths")
+	}
+	if _, err := RatesDeg([]float64{1, 2, 3}, []float64{1, 2, 2}); err == nil {
+		t.Error("expected error for non-increasing times")
+	}
+}
+
+func TestSortFromDeg(t *testing.T) {
+	tests := []struct {
+		angles []float64
+		ref    float64
+		exp    []float64
+	}{
+		{[]float64{350, 10, 180, 0, -20}, 0, []float64{0, 10, 180, -20, 350}},
+		{[]float64{350, 10, 180, 0, -20}, 345, []float64{350, 0, 10, 180, -20}},
+		{[]float64{90, 270, 45}, -90, []float64{270, 45, 90}},
+		{nil, 0, []float64{}},
+	}
+	for _, test := range tests {
+		sorted := SortFromDeg(test.angles, test.ref)
+		if len(sorted) != len(test.exp) {
+			t.Fatalf("SortFromDeg(%v, %f) = %v", test.angles, test.ref, sorted)
+		}
+		for i := range sorted {
+			if sorted[i] != test.exp[i] {
+				t.Errorf("SortFromDeg(%v, %f) = %v, expected %v", test.angles, test.ref, sorted, test.exp)
+				break
+			}
+		}
+	}
+	angles := []float64{ToRad(350), ToRad(10), ToRad(5)}
+	sorted := SortFrom(angles, ToRad(355))
+	if sorted[0] != angles[2] || sorted[1] != angles[1] || sorted[2] != angles[0] {
+		t.Errorf("SortFrom = %v", sorted)
+	}
+	if angles[0] != ToRad(350) {
+		t.Error("SortFrom modified its argument")
+	}
+}
+
+func TestResultant(t *testing.T) {
+	// equal and opposite vectors cancel
+	_, m, err := ResultantDeg([]float64{30, 210}, []float64{5, 5})
+	if err != nil || math.Abs(m) > FP_IGNORE {
+		t.Errorf("opposite vectors: magnitude %f, %v", m, err)
+	}
+	// vectors in the same direction add linearly
+	d, m, err := ResultantDeg([]float64{350, -10}, []float64{2, 3})
+	if err != nil || math.Abs(m-5) > FP_IGNORE || math.Abs(d-350) > FP_IGNORE {
+		t.Errorf("same direction: %f, %f, %v", d, m, err)
+	}
+	d, m, err = Resultant([]float64{0, math.Pi / 2}, []float64{1, 1})
+	if err != nil || math.Abs(m-math.Sqrt2) > FP_IGNORE || math.Abs(d-math.Pi/4) > FP_IGNORE {
+		t.Errorf("orthogonal vectors: %f, %f, %v", d, m, err)
+	}
+	if _, _, err := Resultant([]float64{1, 2}, []float64{1}); err == nil {
+		t.Error("expected error for mismatched lengths")
+	}
+}
+
+func TestDiffDeg(t *testing.T) {
+	tests := []struct{ θ1, θ2, d float64 }{
+		{350, 10, 20},
+		{10, 350, 20},
+		{0, 180, 180},
+		{90, -90, 180},
+		{45, 45, 0},
+		{-30, 30, 60},
+		{720, 1, 1},
+	}
+	for _, test := range tests {
+		if d := DiffDeg(test.θ1, test.θ2); math.Abs(d-test.d) > FP_IGNORE {
+			t.Errorf("DiffDeg(%f, %f) = %f, expected %f", test.θ1, test.θ2, d, test.d)
+		}
+	}
+}
+
+func TestClampToArcDeg(t *testing.T) {
+	tests := []struct{ θ, lo, hi, exp float64 }{
+		// inside the arc
+		{20, 10, 50, 20},
+		{5, 350, 30, 5},
+		{355, 30, 350, 355},
+		// beyond hi
+		{60, 10, 50, 50},
+		{40, 350, 30, 30},
+		// beyond lo
+		{0, 10, 50, 10},
+		{340, 350, 30, 350},
+		// opposite the middle of the arc
+		{210, 10, 50, 10},
+		{210, 50, 10, 50},
+	}
+	for _, test := range tests {
+		if c := ClampToArcDeg(test.θ, test.lo, test.hi); math.Abs(c-test.exp) > FP_IGNORE {
+			t.Errorf("ClampToArcDeg(%f, %f, %f) = %f, expected %f", test.θ, test.lo, test.hi, c, test.exp)
+		}
+	}
+	if c := ClampToArc(math.Pi, -math.Pi/4, math.Pi/4); math.Abs(c+math.Pi/4) > FP_IGNORE {
+		t.Errorf("ClampToArc(π, -π/4, π/4) = %f", c)
+	}
+}
+
+func TestUnitTypes(t *testing.T) {
+	for _, deg := range []Degrees{0, 45, 90, 180, 270, 359.5, -30} {
+		if d := deg.Radians().Degrees(); math.Abs(float64(d-deg)) > FP_IGNORE {
+			t.Errorf("%f degrees round trip to %f", deg, d)
+		}
+	}
+	if d := Degrees(350).Diff(10); math.Abs(float64(d)-20) > FP_IGNORE {
+		t.Errorf("Degrees(350).Diff(10) = %f", d)
+	}
+	if s := Degrees(350).Add(20); math.Abs(float64(s)-10) > FP_IGNORE {
+		t.Errorf("Degrees(350).Add(20) = %f", s)
+	}
+	if d := Radians(math.Pi / 4).Diff(Degrees(315).Radians()); math.Abs(float64(d)-math.Pi/2) > FP_IGNORE {
+		t.Errorf("Radians(π/4).Diff(7π/4) = %f", d)
+	}
+	if cs := Degrees(60).CosineSimilarity(0); math.Abs(cs-0.5) > FP_IGNORE {
+		t.Errorf("Degrees(60).CosineSimilarity(0) = %f", cs)
+	}
+	if cs := Radians(0).CosineSimilarity(Radians(math.Pi / 2)); math.Abs(cs) > FP_IGNORE {
+		t.Errorf("Radians(0).CosineSimilarity(π/2) = %f", cs)
+	}
+}
+
+func TestUnitTypesMismatch(t *testing.T) {
+	const src = `package p
+
+import "github.com/goccmack/goutil/angle"
+
+var _ = angle.Radians(1).Add(angle.Degrees(90))
+`
+	out, err := compile(t, src)
+	if err == nil {
+		t.Fatal("mixing Radians and Degrees compiled")
+	}
+	if !strings.Contains(string(out), "cannot use") {
+		t.Errorf("unexpected compiler output:\n%s", out)
+	}
+}
+
+// compile builds the Go package with the single file src in a temporary module, which replaces
+// the goutil module with this source tree, and returns the output of the compiler.
+func compile(t *testing.T, src string) ([]byte, error) {
+	if testing.Short() {
+		t.Skip("compiling is slow")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "compile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	goMod := "module compile_test\n\n" +
+		"require github.com/goccmack/goutil v0.0.0\n\n" +
+		"replace github.com/goccmack/goutil => " + filepath.ToSlash(root) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the checksums of the dependencies of goutil
+	goSum, err := ioutil.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), goSum, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "build", ".")
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,41 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+/*
+Package geo computes angles between geographic points on a spherical earth.
+*/
+package geo
+
+import (
+	"math"
+
+	"github.com/goccmack/goutil/angle"
+)
+
+/*
+Return the initial compass bearing in degrees [0,360), measured clockwise from north, of the
+great circle path from the point (lat1, lon1) to the point (lat2, lon2). Latitudes and
+longitudes are in degrees. The bearing from a point to itself is 0.
+*/
+func InitialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1, φ2 := angle.ToRad(lat1), angle.ToRad(lat2)
+	Δλ := angle.ToRad(lon2 - lon1)
+	// x points north and y east, so FromSlope returns the clockwise angle from north
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(Δλ)
+	y := math.Sin(Δλ) * math.Cos(φ2)
+	if math.Abs(x) < angle.FP_IGNORE && math.Abs(y) < angle.FP_IGNORE {
+		return 0
+	}
+	return angle.ToDeg(angle.FromSlope(x, y))
+}
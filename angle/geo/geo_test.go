@@ -0,0 +1,45 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInitialBearing(t *testing.T) {
+	tests := []struct {
+		lat1, lon1, lat2, lon2, bearing, tolerance float64
+	}{
+		// due north, east, south and west
+		{0, 0, 10, 0, 0, 1e-9},
+		{0, 0, 0, 10, 90, 1e-9},
+		{10, 20, -5, 20, 180, 1e-9},
+		{0, 10, 0, -10, 270, 1e-9},
+		// across the antimeridian
+		{0, 179, 0, -179, 90, 1e-9},
+		// Land's End to John o' Groats
+		{50.0664, -5.7147, 58.6439, -3.0700, 9.1198, 1e-2},
+		// the same point
+		{1, 2, 1, 2, 0, 1e-9},
+	}
+	for _, test := range tests {
+		b := InitialBearing(test.lat1, test.lon1, test.lat2, test.lon2)
+		if math.Abs(b-test.bearing) > test.tolerance {
+			t.Errorf("InitialBearing(%f, %f, %f, %f) = %f, expected %f",
+				test.lat1, test.lon1, test.lat2, test.lon2, b, test.bearing)
+		}
+	}
+}
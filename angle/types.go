@@ -0,0 +1,82 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package angle
+
+/*
+Radians is an angle in radians. Radians and Degrees are distinct types, so the compiler
+rejects passing one where the other is expected, e.g.: Radians(1).Add(Degrees(90)).
+*/
+type Radians float64
+
+/*
+Degrees is an angle in degrees. See Radians.
+*/
+type Degrees float64
+
+/*
+Return θ in degrees.
+*/
+func (θ Radians) Degrees() Degrees {
+	return Degrees(ToDeg(float64(θ)))
+}
+
+/*
+Return (θ+θ1) mod 2π. See Add.
+*/
+func (θ Radians) Add(θ1 Radians) Radians {
+	return Radians(Add(float64(θ), float64(θ1)))
+}
+
+/*
+Return the smaller angle between θ and θ1. See Diff.
+*/
+func (θ Radians) Diff(θ1 Radians) Radians {
+	return Radians(Diff(float64(θ), float64(θ1)))
+}
+
+/*
+Return the cosine similarity of θ and θ1. See CosineSimilarity.
+*/
+func (θ Radians) CosineSimilarity(θ1 Radians) float64 {
+	return CosineSimilarity(float64(θ), float64(θ1))
+}
+
+/*
+Return θ in radians.
+*/
+func (θ Degrees) Radians() Radians {
+	return Radians(ToRad(float64(θ)))
+}
+
+/*
+Return (θ+θ1) mod 360. See AddDeg.
+*/
+func (θ Degrees) Add(θ1 Degrees) Degrees {
+	return Degrees(AddDeg(float64(θ), float64(θ1)))
+}
+
+/*
+Return the smaller angle between θ and θ1. See DiffDeg.
+*/
+func (θ Degrees) Diff(θ1 Degrees) Degrees {
+	return Degrees(DiffDeg(float64(θ), float64(θ1)))
+}
+
+/*
+Return the cosine similarity of θ and θ1. See CosineSimilarityDeg.
+*/
+func (θ Degrees) CosineSimilarity(θ1 Degrees) float64 {
+	return CosineSimilarityDeg(float64(θ), float64(θ1))
+}
@@ -3,6 +3,7 @@ module github.com/goccmack/goutil
 go 1.13
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/geo v0.0.0-20190916061304-5b978397cfec
 	gonum.org/v1/gonum v0.6.2
 )
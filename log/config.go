@@ -21,18 +21,108 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	logConfigFileSuffix = "log.config"
+
+	// ConfigFileEnv is the environment variable containing the path of the log config file. If it
+	// is set the logger does not look for the log config file in $PWD.
+	ConfigFileEnv = "GOUTIL_LOG_CONFIG"
 )
 
 type jsonConfig struct {
-	RootDir         string `json:",omitempty"`
-	NumFiles        *int   `json:",omitempty"`
-	FileNumBytes    *int   `json:",omitempty"`
-	Priority        string `json:",omitempty"`
-	SuppressedFiles string `json:",omitempty"`
+	RootDir         string            `json:",omitempty"`
+	NumFiles        *int              `json:",omitempty"`
+	FileNumBytes    *int              `json:",omitempty"`
+	Priority        string            `json:",omitempty"`
+	SuppressedFiles string            `json:",omitempty"`
+	LineEnding      string            `json:",omitempty"`
+	CallerStyle     string            `json:",omitempty"`
+	FuncName        bool              `json:",omitempty"`
+	Routes          []*Route          `json:",omitempty"`
+	Identity        bool              `json:",omitempty"`
+	Fields          map[string]string `json:",omitempty"`
+	Sequence        bool              `json:",omitempty"`
+	SequenceBase    uint64            `json:",omitempty"`
+	Format          string            `json:",omitempty"`
+	RefreshInterval duration          `json:",omitempty"`
+	Console         bool              `json:",omitempty"`
+	Color           bool              `json:",omitempty"`
+	ForceColor      bool              `json:",omitempty"`
+	DropWhenFull    bool              `json:",omitempty"`
+	Dedup           bool              `json:",omitempty"`
+	DebugFiles      string            `json:",omitempty"`
+	TimeFormat      string            `json:",omitempty"`
+	UTC             bool              `json:",omitempty"`
+	MinimalHeader   bool              `json:",omitempty"`
+	Compress        bool              `json:",omitempty"`
+	RotateInterval  duration          `json:",omitempty"`
+	MaxTotalBytes   int64             `json:",omitempty"`
+	FileMode        fileMode          `json:",omitempty"`
+	DirMode         fileMode          `json:",omitempty"`
+	FileTimeFormat  string            `json:",omitempty"`
+	Append          bool              `json:",omitempty"`
+}
+
+// duration is a time.Duration in log.config. It is either a duration string, e.g.: "1m30s",
+// or an integer number of seconds.
+type duration time.Duration
+
+// MarshalJSON returns d as a JSON duration string, e.g.: "1m30s".
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON sets d from either a duration string, e.g.: "1m30s", or a number of seconds.
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		d1, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("Invalid duration %s", data)
+		}
+		*d = duration(d1)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("Invalid duration %s", data)
+	}
+	*d = duration(time.Duration(n) * time.Second)
+	return nil
+}
+
+// fileMode is an os.FileMode in log.config. It is either an octal string, e.g.: "0640", or an
+// integer.
+type fileMode os.FileMode
+
+// MarshalJSON returns m as a JSON octal string, e.g.: "0640".
+func (m fileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#o", uint32(m)))
+}
+
+// UnmarshalJSON sets m from either an octal string, e.g.: "0640", or an integer.
+func (m *fileMode) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		n, err := strconv.ParseUint(str, 8, 32)
+		if err != nil || os.FileMode(n)&^os.ModePerm != 0 {
+			return fmt.Errorf("Invalid file mode %s", data)
+		}
+		*m = fileMode(n)
+		return nil
+	}
+	var n uint32
+	if err := json.Unmarshal(data, &n); err != nil || os.FileMode(n)&^os.ModePerm != 0 {
+		return fmt.Errorf("Invalid file mode %s", data)
+	}
+	*m = fileMode(n)
+	return nil
 }
 
 // Config contains the logger configuration. It is read from the JSON file log.config in the
@@ -43,8 +133,175 @@ type Config struct {
 	NumFiles     int
 	FileNumBytes int
 	Priority     Priority
-	// comma separated list of files whose DEBUG messages are suppressed
+	// comma separated list of files whose DEBUG and TRACE messages are suppressed, see Suppress
 	SuppressedFiles string
+	// line terminator of every line written to the log: "\n" or "\r\n"
+	LineEnding string
+	// CallerStyle determines how the source file of a message is shown:
+	// "base", e.g. "handler.go"; "package/file", e.g. "log/handler.go"; or "full", the full path.
+	CallerStyle string
+	// FuncName adds the name of the calling function to the source file of a message, e.g.:
+	// "-main.go/doWork, line 14-" in the text format and a "func" field in the JSON format
+	FuncName bool
+	// Routes direct messages of selected priorities to their own sets of log files
+	Routes []*Route
+	// Identity adds the hostname and pid fields, identifying the process, to every message
+	Identity bool
+	// Fields are added to every message, e.g.: {"service": "billing"}
+	Fields map[string]string
+	// Sequence adds a sequence number, starting at SequenceBase when the logger is
+	// initialised and incremented by one for every message written, to every message
+	Sequence     bool
+	SequenceBase uint64
+	// Format is the format of the log messages: "text" or "json", one JSON object per line
+	Format string
+	// RefreshInterval is the interval at which the logger checks log.config for changes if the
+	// log config file cannot be watched for changes
+	RefreshInterval time.Duration
+	// Console mirrors every message to the terminal: EXIT, PANIC and WARNING messages to
+	// os.Stderr and INFO, DEBUG and TRACE messages to os.Stdout
+	Console bool
+	// Color colors the console output by priority if the console stream is a terminal.
+	// ForceColor colors the console output even if it is not a terminal, e.g. for a CI log.
+	// The log files are never colored.
+	Color      bool
+	ForceColor bool
+	// DropWhenFull discards messages logged while the message buffer of the logger is full,
+	// instead of blocking the caller until there is space. Discarded messages are counted in
+	// Stats().Dropped.
+	DropWhenFull bool
+	// Dedup collapses consecutive identical messages into the first message followed by
+	// "previous message repeated N times"
+	Dedup bool
+	// DebugFiles, if it is not empty, is the comma separated list of the only files whose DEBUG
+	// and TRACE messages are logged, see EnableDebugFor
+	DebugFiles string
+	// TimeFormat is the Go time layout of the message timestamps, e.g.: "2006-01-02 15:04:05.000".
+	// files.MergeReadersLayout merges log files with this layout.
+	// UTC shows the timestamps in UTC instead of local time.
+	TimeFormat string
+	UTC        bool
+	// MinimalHeader omits the hostname and pid of the process from the header at the start of
+	// every log file
+	MinimalHeader bool
+	// Compress gzips the log files when they are rotated out, see files.Config.Compress
+	Compress bool
+	// RotateInterval, if it is not 0, starts a new log file at this interval, e.g. "24h" for
+	// daily log files starting at midnight, see files.Config.RotateInterval
+	RotateInterval time.Duration
+	// MaxTotalBytes, if it is not 0, limits the total size of the log files of the logger and of
+	// each route, see files.Config.MaxTotalBytes
+	MaxTotalBytes int64
+	// FileMode and DirMode, if they are not 0, are the permissions of the log files and of the
+	// log directories created by the logger, e.g. 0640 and 0750, see files.Config.FileMode. In
+	// log.config they are octal strings, e.g.: "0640". A changed FileMode applies to the log
+	// files created after the change, a changed DirMode after a restart.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	// FileTimeFormat, if it is not empty, is the Go time layout of the time in the log file
+	// names, e.g.: "20060102-150405", see files.Config.FileTimeFormat. A changed FileTimeFormat
+	// applies after a restart, so that the names of the open log files sort in time order.
+	FileTimeFormat string
+	// Append continues the newest log file at startup if it is not full, instead of starting a
+	// new file, see files.Config.Append. A changed Append applies at the next startup.
+	Append bool
+}
+
+/*
+Route directs the messages with priorities in [From, To] to a separate set of log files
+instead of the log files of Config. From and To may be equal to route a single priority.
+A message is written to the first route covering its priority, or to the log files of
+Config if there is none.
+
+The log files of a route are named <FileName>.<from>-<to>, or <FileName>.<priority> if
+From and To are equal, e.g.: myapp.warning. Empty RootDir, NumFiles and FileNumBytes default
+to the values of Config.
+
+In log.config a route is given as, e.g.:
+
+	{ "From": "PANIC", "To": "WARNING", "RootDir": "/var/log/problems" }
+
+One route per priority writes every priority to its own set of log files, e.g. myapp.warning and
+myapp.info, with the messages of the remaining priorities in the log files of Config:
+
+	"Routes": [{ "From": "WARNING", "To": "WARNING" }, { "From": "INFO", "To": "INFO" }]
+
+Flush and Close write the pending messages to the log files of all routes, and Close closes them.
+*/
+type Route struct {
+	From         Priority
+	To           Priority
+	RootDir      string `json:",omitempty"`
+	NumFiles     int    `json:",omitempty"`
+	FileNumBytes int    `json:",omitempty"`
+}
+
+// Covers returns true iff p is in the priority range of r
+func (r *Route) Covers(p Priority) bool {
+	from, to := r.From, r.To
+	if from > to {
+		from, to = to, from
+	}
+	return from <= p && p <= to
+}
+
+// Name returns the name of the priority range of r, e.g.: "warning" or "panic-warning"
+func (r *Route) Name() string {
+	if r.From == r.To {
+		return strings.ToLower(r.From.String())
+	}
+	return strings.ToLower(r.From.String() + "-" + r.To.String())
+}
+
+func (r *Route) String() string {
+	return fmt.Sprintf("Route{%s,%s,%s,%d,%d}", r.From, r.To, r.RootDir, r.NumFiles, r.FileNumBytes)
+}
+
+func cloneRoutes(routes []*Route) []*Route {
+	if routes == nil {
+		return nil
+	}
+	clone := make([]*Route, len(routes))
+	for i, r := range routes {
+		r1 := *r
+		clone[i] = &r1
+	}
+	return clone
+}
+
+func cloneFields(fields map[string]string) map[string]string {
+	if fields == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(fields))
+	for k, v := range fields {
+		clone[k] = v
+	}
+	return clone
+}
+
+func equalFields(fields, fields1 map[string]string) bool {
+	if len(fields) != len(fields1) {
+		return false
+	}
+	for k, v := range fields {
+		if v1, exist := fields1[k]; !exist || v1 != v {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRoutes(routes, routes1 []*Route) bool {
+	if len(routes) != len(routes1) {
+		return false
+	}
+	for i, r := range routes {
+		if *r != *routes1[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // Clone returns a deep copy of c
@@ -56,6 +313,32 @@ func (c *Config) Clone() *Config {
 		FileNumBytes:    c.FileNumBytes,
 		Priority:        c.Priority,
 		SuppressedFiles: c.SuppressedFiles,
+		LineEnding:      c.LineEnding,
+		CallerStyle:     c.CallerStyle,
+		FuncName:        c.FuncName,
+		Routes:          cloneRoutes(c.Routes),
+		Identity:        c.Identity,
+		Fields:          cloneFields(c.Fields),
+		Sequence:        c.Sequence,
+		SequenceBase:    c.SequenceBase,
+		Format:          c.Format,
+		RefreshInterval: c.RefreshInterval,
+		Console:         c.Console,
+		Color:           c.Color,
+		ForceColor:      c.ForceColor,
+		DropWhenFull:    c.DropWhenFull,
+		Dedup:           c.Dedup,
+		DebugFiles:      c.DebugFiles,
+		TimeFormat:      c.TimeFormat,
+		UTC:             c.UTC,
+		MinimalHeader:   c.MinimalHeader,
+		Compress:        c.Compress,
+		RotateInterval:  c.RotateInterval,
+		MaxTotalBytes:   c.MaxTotalBytes,
+		FileMode:        c.FileMode,
+		DirMode:         c.DirMode,
+		FileTimeFormat:  c.FileTimeFormat,
+		Append:          c.Append,
 	}
 }
 
@@ -65,7 +348,33 @@ func (c *Config) Equal(c1 *Config) bool {
 		c.FileName != c1.FileName ||
 		c.NumFiles != c1.NumFiles ||
 		c.FileNumBytes != c1.FileNumBytes ||
-		c.Priority != c1.Priority {
+		c.Priority != c1.Priority ||
+		c.LineEnding != c1.LineEnding ||
+		c.CallerStyle != c1.CallerStyle ||
+		c.FuncName != c1.FuncName ||
+		!equalRoutes(c.Routes, c1.Routes) ||
+		c.Identity != c1.Identity ||
+		!equalFields(c.Fields, c1.Fields) ||
+		c.Sequence != c1.Sequence ||
+		c.SequenceBase != c1.SequenceBase ||
+		c.Format != c1.Format ||
+		c.RefreshInterval != c1.RefreshInterval ||
+		c.Console != c1.Console ||
+		c.Color != c1.Color ||
+		c.ForceColor != c1.ForceColor ||
+		c.DropWhenFull != c1.DropWhenFull ||
+		c.Dedup != c1.Dedup ||
+		c.DebugFiles != c1.DebugFiles ||
+		c.TimeFormat != c1.TimeFormat ||
+		c.UTC != c1.UTC ||
+		c.MinimalHeader != c1.MinimalHeader ||
+		c.Compress != c1.Compress ||
+		c.RotateInterval != c1.RotateInterval ||
+		c.MaxTotalBytes != c1.MaxTotalBytes ||
+		c.FileMode != c1.FileMode ||
+		c.DirMode != c1.DirMode ||
+		c.FileTimeFormat != c1.FileTimeFormat ||
+		c.Append != c1.Append {
 
 		return false
 	}
@@ -88,16 +397,14 @@ func (c *Config) String() string {
 // 		    "NumFiles": 3,
 // 		    "FileNumBytes": 1000000,
 // 		    "Priority": "INFO",
-// 		    "SuppressedFiles": ""
+// 		    "LineEnding": "\n",
+// 		    "CallerStyle": "base",
+// 		    "Format": "text",
+// 		    "RefreshInterval": "10s",
+// 		    "TimeFormat": "2006-01-02T15:04:05.999999999Z07:00"
 // 		}
 func (c *Config) ToJSON() string {
-	jc := &jsonConfig{
-		RootDir:      c.RootDir,
-		NumFiles:     &c.NumFiles,
-		FileNumBytes: &c.FileNumBytes,
-		Priority:     c.Priority.String(),
-	}
-	b, err := json.Marshal(jc)
+	b, err := json.Marshal(c.toJSONConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -106,6 +413,43 @@ func (c *Config) ToJSON() string {
 	return out.String()
 }
 
+// toJSONConfig returns the log.config representation of c
+func (c *Config) toJSONConfig() *jsonConfig {
+	return &jsonConfig{
+		RootDir:         c.RootDir,
+		NumFiles:        &c.NumFiles,
+		FileNumBytes:    &c.FileNumBytes,
+		Priority:        c.Priority.String(),
+		SuppressedFiles: c.SuppressedFiles,
+		LineEnding:      c.LineEnding,
+		CallerStyle:     c.CallerStyle,
+		FuncName:        c.FuncName,
+		Routes:          c.Routes,
+		Identity:        c.Identity,
+		Fields:          c.Fields,
+		Sequence:        c.Sequence,
+		SequenceBase:    c.SequenceBase,
+		Format:          c.Format,
+		RefreshInterval: duration(c.RefreshInterval),
+		Console:         c.Console,
+		Color:           c.Color,
+		ForceColor:      c.ForceColor,
+		DropWhenFull:    c.DropWhenFull,
+		Dedup:           c.Dedup,
+		DebugFiles:      c.DebugFiles,
+		TimeFormat:      c.TimeFormat,
+		UTC:             c.UTC,
+		MinimalHeader:   c.MinimalHeader,
+		Compress:        c.Compress,
+		RotateInterval:  duration(c.RotateInterval),
+		MaxTotalBytes:   c.MaxTotalBytes,
+		FileMode:        fileMode(c.FileMode),
+		DirMode:         fileMode(c.DirMode),
+		FileTimeFormat:  c.FileTimeFormat,
+		Append:          c.Append,
+	}
+}
+
 var (
 	// Name of the executeable file; will be used to create logfile names
 	fileName = getFileName()
@@ -123,6 +467,36 @@ const (
 	DefaultPriority = INFO
 	// DefaultSuppressedFiles determines the suppressed files if not specified in log.config
 	DefaultSuppressedFiles = ""
+	// DefaultLineEnding determines the line terminator if not specified in log.config
+	DefaultLineEnding = "\n"
+	// DefaultCallerStyle determines how source files are shown if not specified in log.config
+	DefaultCallerStyle = CallerBase
+	// DefaultFormat determines the format of log messages if not specified in log.config
+	DefaultFormat = FormatText
+	// DefaultRefreshInterval determines how often log.config is checked for changes if it cannot
+	// be watched and the interval is not specified in log.config
+	DefaultRefreshInterval = 10 * time.Second
+	// DefaultTimeFormat determines the layout of message timestamps if not specified in log.config
+	DefaultTimeFormat = time.RFC3339Nano
+)
+
+// Formats of log messages
+const (
+	// FormatText writes messages as text, e.g.: 2020-01-02T15:04:05Z [INFO] -main.go, line 14- msg
+	FormatText = "text"
+	// FormatJSON writes every message as a JSON object on one line, e.g.:
+	// {"time":"2020-01-02T15:04:05Z","level":"INFO","file":"main.go","line":14,"msg":"msg"}
+	FormatJSON = "json"
+)
+
+// Caller styles
+const (
+	// CallerBase shows the base name of the source file, e.g. "handler.go"
+	CallerBase = "base"
+	// CallerPackage shows the directory and base name of the source file, e.g. "log/handler.go"
+	CallerPackage = "package/file"
+	// CallerFull shows the full path of the source file
+	CallerFull = "full"
 )
 
 // DefaultConfig returns the default configuration
@@ -134,13 +508,23 @@ func DefaultConfig() *Config {
 		FileNumBytes:    DefaultLogFileNumBytes,
 		Priority:        DefaultPriority,
 		SuppressedFiles: DefaultSuppressedFiles,
+		LineEnding:      DefaultLineEnding,
+		CallerStyle:     DefaultCallerStyle,
+		Format:          DefaultFormat,
+		RefreshInterval: DefaultRefreshInterval,
+		TimeFormat:      DefaultTimeFormat,
 	}
 }
 
+// getConfigFile returns the path of the log config file, or "" if there is none
 func getConfigFile() string {
+	if pth := os.Getenv(ConfigFileEnv); pth != "" {
+		return pth
+	}
 	files, err := ioutil.ReadDir(".")
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Error reading the log config file directory: %s\n", err)
+		return ""
 	}
 	for _, f := range files {
 		if f.Name() == fmt.Sprintf("%s.%s", fileName, logConfigFileSuffix) {
@@ -155,13 +539,66 @@ func getConfigFile() string {
 	return ""
 }
 
+// configFileState identifies the log config file and its contents at one time
+type configFileState struct {
+	name string
+	info os.FileInfo
+}
+
+// configFileNames returns the paths at which the log config file is looked for, in order of
+// preference
+func configFileNames() []string {
+	if pth := os.Getenv(ConfigFileEnv); pth != "" {
+		return []string{pth}
+	}
+	return []string{fmt.Sprintf("%s.%s", fileName, logConfigFileSuffix), logConfigFileSuffix}
+}
+
+// isConfigFile returns true if pth is one of the paths of the log config file
+func isConfigFile(pth string) bool {
+	for _, name := range configFileNames() {
+		if filepath.Clean(pth) == filepath.Clean(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// statConfigFile returns the state of the log config file. The name of the state is empty if
+// there is no log config file.
+func statConfigFile() *configFileState {
+	for _, name := range configFileNames() {
+		if info, err := os.Stat(name); err == nil {
+			return &configFileState{name: name, info: info}
+		}
+	}
+	return &configFileState{}
+}
+
+// changed returns true if the log config file has been created, removed, replaced or written
+// between the states s and s1. A nil s has unknown state.
+func (s *configFileState) changed(s1 *configFileState) bool {
+	if s == nil || s.name != s1.name {
+		return true
+	}
+	if s.name == "" {
+		return false
+	}
+	return !os.SameFile(s.info, s1.info) ||
+		!s.info.ModTime().Equal(s1.info.ModTime()) ||
+		s.info.Size() != s1.info.Size()
+}
+
 func getFileName() string {
 	pth, err := os.Executable()
 	if err != nil {
-		panic(err)
+		// e.g. on a platform without /proc
+		pth = os.Args[0]
+	}
+	if _, fname := path.Split(filepath.ToSlash(pth)); fname != "" {
+		return fname
 	}
-	_, fname := path.Split(pth)
-	return fname
+	return "log"
 }
 
 func jsonToConfig(jc *jsonConfig) *Config {
@@ -193,9 +630,87 @@ func jsonToConfig(jc *jsonConfig) *Config {
 		}
 	}
 	c.SuppressedFiles = jc.SuppressedFiles
+	switch jc.LineEnding {
+	case "":
+		c.LineEnding = DefaultLineEnding
+	case "\n", "\r\n":
+		c.LineEnding = jc.LineEnding
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid line ending: %q\n", jc.LineEnding)
+		c.LineEnding = DefaultLineEnding
+	}
+	switch jc.CallerStyle {
+	case "":
+		c.CallerStyle = DefaultCallerStyle
+	case CallerBase, CallerPackage, CallerFull:
+		c.CallerStyle = jc.CallerStyle
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid caller style: %s\n", jc.CallerStyle)
+		c.CallerStyle = DefaultCallerStyle
+	}
+	switch jc.Format {
+	case "":
+		c.Format = DefaultFormat
+	case FormatText, FormatJSON:
+		c.Format = jc.Format
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid log format: %s\n", jc.Format)
+		c.Format = DefaultFormat
+	}
+	switch {
+	case jc.RefreshInterval == 0:
+		c.RefreshInterval = DefaultRefreshInterval
+	case jc.RefreshInterval < 0:
+		fmt.Fprintf(os.Stderr, "Invalid refresh interval: %s\n", time.Duration(jc.RefreshInterval))
+		c.RefreshInterval = DefaultRefreshInterval
+	default:
+		c.RefreshInterval = time.Duration(jc.RefreshInterval)
+	}
+	c.FuncName = jc.FuncName
+	c.Routes = jc.Routes
+	c.Identity = jc.Identity
+	c.Fields = jc.Fields
+	c.Sequence = jc.Sequence
+	c.SequenceBase = jc.SequenceBase
+	c.Console = jc.Console
+	c.Color = jc.Color
+	c.ForceColor = jc.ForceColor
+	c.DropWhenFull = jc.DropWhenFull
+	c.Dedup = jc.Dedup
+	c.DebugFiles = jc.DebugFiles
+	c.TimeFormat = checkTimeFormat(jc.TimeFormat)
+	c.UTC = jc.UTC
+	c.MinimalHeader = jc.MinimalHeader
+	c.Compress = jc.Compress
+	if jc.RotateInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid rotate interval: %s\n", time.Duration(jc.RotateInterval))
+	} else {
+		c.RotateInterval = time.Duration(jc.RotateInterval)
+	}
+	c.MaxTotalBytes = jc.MaxTotalBytes
+	c.FileMode = os.FileMode(jc.FileMode)
+	c.DirMode = os.FileMode(jc.DirMode)
+	c.FileTimeFormat = jc.FileTimeFormat
+	c.Append = jc.Append
 	return c
 }
 
+// checkTimeFormat returns the time layout tf, or DefaultTimeFormat if tf is empty or is not a
+// valid layout, in which case a warning is written to os.Stderr.
+func checkTimeFormat(tf string) string {
+	if tf == "" {
+		return DefaultTimeFormat
+	}
+	// a layout without any elements formats every time as itself
+	tm := time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC)
+	str := tm.Format(tf)
+	if _, err := time.Parse(tf, str); err != nil || str == tf {
+		fmt.Fprintf(os.Stderr, "Invalid time format: %q\n", tf)
+		return DefaultTimeFormat
+	}
+	return tf
+}
+
 func readConfigFile(warnIfNoCfg bool) *Config {
 	cfgFile := getConfigFile()
 	if cfgFile == "" {
@@ -0,0 +1,89 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"time"
+)
+
+// repeatFlushInterval is the maximum time for which repeats of a message are held back
+const repeatFlushInterval = time.Second
+
+// lastMsg is the last message written by a logger configured with Dedup, and the number of
+// its consecutive repeats that have been held back
+type lastMsg struct {
+	priority Priority
+	pc       uintptr
+	file     string
+	line     int
+	// text is the formatted message followed by the text of its fields
+	text    string
+	repeats uint64
+}
+
+// isRepeat returns true if the message is a repeat of the last message written by l, in which
+// case the repeat is counted instead of being written. Otherwise the held back repeats of
+// the last message are written and the message becomes the last message.
+func (l *logger) isRepeat(priority Priority, pc uintptr, file string, line int, msg string,
+	fields []*field) bool {
+
+	if !l.cfg.Dedup {
+		l.flushRepeats()
+		l.last = nil
+		return false
+	}
+	text := msg + fieldsText(fields)
+	if last := l.last; last != nil && last.priority == priority && last.file == file &&
+		last.line == line && last.text == text {
+
+		if last.repeats == 0 {
+			l.repeatTimer = time.NewTimer(repeatFlushInterval)
+		}
+		last.repeats++
+		return true
+	}
+	l.flushRepeats()
+	l.last = &lastMsg{priority: priority, pc: pc, file: file, line: line, text: text}
+	return false
+}
+
+// flushRepeats writes the number of held back repeats of the last message, e.g.:
+// "previous message repeated 3 times".
+func (l *logger) flushRepeats() {
+	if l.repeatTimer != nil {
+		l.repeatTimer.Stop()
+		l.repeatTimer = nil
+	}
+	last := l.last
+	if last == nil || last.repeats == 0 {
+		return
+	}
+	msg := "previous message repeated once"
+	if last.repeats > 1 {
+		msg = fmt.Sprintf("previous message repeated %d times", last.repeats)
+	}
+	l.writeEntry(last.priority, l.entry(last.priority, 0, last.pc, last.file, last.line, msg, "", nil))
+	last.repeats = 0
+}
+
+// repeatFlush returns the channel of the timer of the held back repeats, or nil if no repeats
+// are held back
+func (l *logger) repeatFlush() <-chan time.Time {
+	if l.repeatTimer == nil {
+		return nil
+	}
+	return l.repeatTimer.C
+}
@@ -0,0 +1,149 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+/*
+Logger logs messages with a fixed set of key/value fields. A Logger is returned by WithFields
+and is immutable, so it may be used by several goroutines at the same time.
+
+In text format the fields are appended to each message, e.g.:
+
+	log.WithFields(map[string]interface{}{"request_id": 42, "user": "bob"}).Info("login")
+
+logs:
+
+	2020-06-01T10:00:00.000000000+02:00 [INFO] -main.go, line 21- login request_id=42 user=bob
+
+In JSON format the fields are members of the message object.
+*/
+type Logger struct {
+	fields []*field
+}
+
+// contextKey is the key of the Logger of a context.Context
+type contextKey struct{}
+
+// noFields is the Logger without fields
+var noFields = new(Logger)
+
+// WithFields returns a Logger that logs every message with fields.
+func WithFields(fields map[string]interface{}) *Logger {
+	return noFields.WithFields(fields)
+}
+
+// WithContext returns a copy of ctx carrying the Logger of ctx with the additional fields.
+// The Logger is returned by FromContext.
+func WithContext(ctx context.Context, fields map[string]interface{}) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the Logger of ctx set by WithContext. If ctx has no Logger FromContext
+// returns a Logger without fields, which logs like the package level functions, e.g. Info.
+func FromContext(ctx context.Context) *Logger {
+	if lgr, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return lgr
+	}
+	return noFields
+}
+
+// WithFields returns a new Logger with the fields of lgr and fields. A key in fields
+// replaces the same key of lgr.
+func (lgr *Logger) WithFields(fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(lgr.fields)+len(fields))
+	for _, f := range lgr.fields {
+		merged[f.key] = f.value
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lgr1 := &Logger{fields: make([]*field, len(keys))}
+	for i, k := range keys {
+		lgr1.fields[i] = &field{key: k, value: merged[k]}
+	}
+	return lgr1
+}
+
+// Exitf logs a formatted message with the fields of lgr followed by os.Exit(exitCode)
+func (lgr *Logger) Exitf(exitCode int, format string, a ...interface{}) {
+	exitIF(exitCode, fmt.Sprintf(format, a...), lgr.fields)
+}
+
+// Panicf logs a formatted message with the fields of lgr followed by a stack trace;
+// flushes and closes the log file and then performs os.Exit(1)
+func (lgr *Logger) Panicf(format string, a ...interface{}) {
+	panicIF(fmt.Sprintf(format, a...), getPanicStackTrace(), lgr.fields)
+}
+
+// Warningf logs a formatted message with the fields of lgr with priority Warning.
+func (lgr *Logger) Warningf(format string, a ...interface{}) {
+	logIF(WARNING, format, a, lgr.fields)
+}
+
+// Infof logs a formatted message with the fields of lgr with priority Info.
+func (lgr *Logger) Infof(format string, a ...interface{}) {
+	logIF(INFO, format, a, lgr.fields)
+}
+
+// Debugf logs a formatted message with the fields of lgr with priority Debug.
+func (lgr *Logger) Debugf(format string, a ...interface{}) {
+	logIF(DEBUG, format, a, lgr.fields)
+}
+
+// Tracef logs a formatted message with the fields of lgr with priority Trace.
+func (lgr *Logger) Tracef(format string, a ...interface{}) {
+	logIF(TRACE, format, a, lgr.fields)
+}
+
+// Exit logs a message with the fields of lgr followed by os.Exit(exitCode)
+func (lgr *Logger) Exit(exitCode int, msg string) {
+	exitIF(exitCode, msg, lgr.fields)
+}
+
+// Panic logs a message with the fields of lgr followed by a stack trace;
+// flushes and closes the log file and then performs os.Exit(1)
+func (lgr *Logger) Panic(msg string) {
+	panicIF(msg, getPanicStackTrace(), lgr.fields)
+}
+
+// Warning logs a message with the fields of lgr with priority Warning.
+func (lgr *Logger) Warning(msg string) {
+	msgIF(WARNING, msg, lgr.fields)
+}
+
+// Info logs a message with the fields of lgr with priority Info.
+func (lgr *Logger) Info(msg string) {
+	msgIF(INFO, msg, lgr.fields)
+}
+
+// Debug logs a message with the fields of lgr with priority Debug.
+func (lgr *Logger) Debug(msg string) {
+	msgIF(DEBUG, msg, lgr.fields)
+}
+
+// Trace logs a message with the fields of lgr with priority Trace.
+func (lgr *Logger) Trace(msg string) {
+	msgIF(TRACE, msg, lgr.fields)
+}
@@ -0,0 +1,147 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package files
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// compressedSuffix is appended to the name of a compressed log file
+const compressedSuffix = ".gz"
+
+// compressQueueSize is the number of log files that may wait for compression. A file that
+// cannot be queued is queued again at the next rotation.
+const compressQueueSize = 16
+
+// compressor compresses the log files queued on fs.compressChan until the channel is closed
+func (fs *FileSet) compressor() {
+	for fname := range fs.compressChan {
+		if err := compressFile(fname); err != nil {
+			fmt.Fprintf(os.Stderr, "Error compressing log file %s: %s\n", fname, err)
+		}
+	}
+	close(fs.compressDone)
+}
+
+// setCompress starts the compressor of fs if compress is set and stops it, after the pending
+// compressions, otherwise
+func (fs *FileSet) setCompress(compress bool) {
+	switch {
+	case compress && fs.compressChan == nil:
+		fs.compressChan = make(chan string, compressQueueSize)
+		fs.compressDone = make(chan bool)
+		go fs.compressor()
+	case !compress && fs.compressChan != nil:
+		close(fs.compressChan)
+		<-fs.compressDone
+		fs.compressChan, fs.compressDone = nil, nil
+	}
+}
+
+// queueCompress queues the uncompressed log files of logFiles for compression without blocking
+func (fs *FileSet) queueCompress(logFiles []string) {
+	for _, fname := range logFiles {
+		if strings.HasSuffix(fname, compressedSuffix) {
+			continue
+		}
+		select {
+		case fs.compressChan <- fname:
+		default:
+			return
+		}
+	}
+}
+
+/*
+compressFile replaces the log file fname by the gzip compressed file fname.gz with the
+permission of fname. The compressed file is written to a temporary file first, so that a log
+file is never listed twice or lost if the program exits during compression. A file that is
+deleted before or during its compression is ignored.
+*/
+func compressFile(fname string) error {
+	in, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	mode := fi.Mode().Perm()
+	tmp := fname + compressedSuffix + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err1 := gz.Close(); err == nil {
+		err = err1
+	}
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := os.Stat(fname); os.IsNotExist(err) {
+		// the log file was deleted by a rotation during compression
+		os.Remove(tmp)
+		return nil
+	}
+	if err := os.Rename(tmp, fname+compressedSuffix); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(fname)
+}
+
+// openLogFile opens the log file fname for reading, decompressing it if it is compressed
+func openLogFile(fname string) (io.ReadCloser, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fname, compressedSuffix) {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Error reading log file %s: %s", fname, err)
+	}
+	return &gzipFile{gz, f}, nil
+}
+
+// gzipFile is the decompressed content of a compressed log file
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+// Close closes the compressed log file
+func (gf *gzipFile) Close() error {
+	gf.Reader.Close()
+	return gf.f.Close()
+}
@@ -0,0 +1,99 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+dedup removes the log file closed from logFiles, which are sorted from oldest to newest, if
+its contents are identical to those of the preceding file, and returns the remaining files and
+the path of the removed file, or "" if no file was removed. If closed is "" the newest log
+file is checked, e.g. the file closed by a previous FileSet.
+*/
+func dedup(logFiles []string, closed string) ([]string, string, error) {
+	i := len(logFiles) - 1
+	if closed != "" {
+		for i >= 0 && logFiles[i] != closed {
+			i--
+		}
+	}
+	if i < 1 {
+		return logFiles, "", nil
+	}
+	h, err := contentHash(logFiles[i])
+	if err != nil {
+		return nil, "", err
+	}
+	h1, err := contentHash(logFiles[i-1])
+	if err != nil {
+		return nil, "", err
+	}
+	if !bytes.Equal(h, h1) {
+		return logFiles, "", nil
+	}
+	removed := logFiles[i]
+	err = os.Remove(removed)
+	if os.IsNotExist(err) {
+		removed += compressedSuffix
+		err = os.Remove(removed)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return append(logFiles[:i:i], logFiles[i+1:]...), removed, nil
+}
+
+// contentHash returns the SHA-256 hash of the contents of fname following its file set header.
+// If fname no longer exists the hash of its compressed file is returned, see Config.Compress.
+func contentHash(fname string) ([]byte, error) {
+	f, err := openLogFile(fname)
+	if os.IsNotExist(err) && !strings.HasSuffix(fname, compressedSuffix) {
+		// the compressor replaced fname since it was listed
+		f, err = openLogFile(fname + compressedSuffix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rdr := bufio.NewReader(f)
+	h := sha256.New()
+	line, err := rdr.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(line, jsonHeaderPrefix):
+		// the header is one line
+	case strings.HasPrefix(line, headerPrefix):
+		for i := 1; i < headerLines; i++ {
+			if _, err := rdr.ReadString('\n'); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+	default:
+		io.WriteString(h, line)
+	}
+	if _, err := io.Copy(h, rdr); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
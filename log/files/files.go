@@ -14,33 +14,168 @@
 
 /*
 Package files implements a managed fileset writer/closer.
+
+A FileSet is an io.WriteCloser that may be handed to libraries expecting one: Write returns
+an error instead of panicking, Close may be called more than once, and Write, WriteString and
+Sync return ErrClosed after Close.
+
+The symbolic link <LogDir>/<LogName>-latest.log points to the current log file of a FileSet,
+so that e.g. tail -F <LogName>-latest.log follows the log across rotations. The link is not
+created on platforms without symbolic links.
 */
 package files
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrClosed is returned by Write, WriteString and Sync after the FileSet has been closed
+var ErrClosed = errors.New("write to closed log file set")
+
+// ErrDropped is returned by Write when the write was dropped by the overflow policy
+var ErrDropped = errors.New("write dropped: log queue full")
+
+// ErrTimeout is returned by Write, SetConfig and Close when the FileSet does not complete the
+// call within its timeout, e.g. because the disk is stalled. A timed out call may still complete.
+var ErrTimeout = errors.New("timeout waiting for log file set")
+
+// OverflowPolicy determines the behaviour of Write when the write queue of a FileSet is full
+type OverflowPolicy int
+
+const (
+	// Block makes Write wait until the write can be queued
+	Block OverflowPolicy = iota
+	// DropNewest makes Write drop its buffer if it cannot be queued within the overflow timeout
+	DropNewest
+	// DropOldest makes Write drop the oldest queued write to make room for its buffer
+	DropOldest
+)
+
+/*
+DefaultNameTemplate is the template of log file names used if Config.NameTemplate is empty.
+
+A name template may contain the following placeholders:
+
+	{name}	the log name of the FileSet
+	{time}	the creation time of the file in the layout of Config.FileTimeFormat, by default RFC3339Nano
+	{seq}	the six digit sequence number of the file, starting at 000001 when the FileSet is opened
+
+A template must contain {time}. Log files are ordered by name, so {seq}, if it is used, must
+follow {time}, and any text preceding {time} must be the same for all files of a FileSet.
+*/
+const DefaultNameTemplate = "{name}_{time}.log"
+
+// latestSuffix is appended to the log name of a FileSet to form the name of the symbolic link
+// to its current log file, e.g.: myapp-latest.log
+const latestSuffix = "-latest.log"
+
+// DefaultFileMode is the default permission of the log files, before the umask is applied
+const DefaultFileMode os.FileMode = 0666
+
+// DefaultDirMode is the default permission of the log directories, before the umask is applied
+const DefaultDirMode os.FileMode = os.ModePerm
+
+// DefaultOverflowTimeout is the time DropNewest waits to queue a write if Config.OverflowTimeout is 0
+const DefaultOverflowTimeout = time.Second
+
+// DefaultTimeout is the time SetConfig and Close wait for the FileSet if Config.Timeout is 0
+const DefaultTimeout = time.Second
+
+// DefaultBufferSize is the size of the write buffer of a FileSet if Config.BufferSize is 0
+const DefaultBufferSize = 32 * 1024
+
+// DefaultFlushInterval is the longest time a write is buffered if Config.FlushInterval is 0
+const DefaultFlushInterval = 100 * time.Millisecond
+
 type FileSet struct {
-	closeChan       chan chan bool
+	// buf buffers the writes to currentFile. It is nil if writes are not buffered. flushTimer
+	// triggers the flush of buf. It is nil if buf is empty.
+	buf             *bufio.Writer
+	bufSize         int
+	closeChan       chan chan error
+	closed          bool
+	compressChan    chan string
+	compressDone    chan bool
+	created         time.Time
 	currentFile     *os.File
 	currentFileSize int
+	dedup           bool
+	dropped         uint64
+	fileMode        os.FileMode
+	fileTimeFormat  string
+	flushChan       chan chan error
+	flushInterval   time.Duration
+	flushTimer      *time.Timer
+	header          string
+	headerChan      chan *setHeader
+	healthChan      chan chan error
+	statsChan       chan chan *Stats
+	jsonHeader      bool
 	logDir          string
 	logName         string
 	maxFileSize     int
 	maxNumFiles     int
+	maxTotalBytes   int64
+	// mu guards closed. Writes are queued with mu read locked.
+	mu              sync.RWMutex
 	msgChan         chan *writeRequest
-	setConfigChan   chan *setConfig
+	onDelete        func(path string)
+	onDeleteChan    chan *setOnDelete
+	onRotate        func(closedPath, newPath string)
+	onRotateChan    chan *setOnRotate
+	nameTemplate    string
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	timeout         time.Duration
+	writeTimeout    time.Duration
+	retentionAge    time.Duration
+	rotateChan      chan chan error
+	// rotateTimer triggers the rotation at the end of the interval of the current file. It is nil
+	// if RotateInterval is 0. rotateDue is set if the interval of the empty current file ended.
+	rotateDue      bool
+	rotateInterval time.Duration
+	rotateTimer    *time.Timer
+	seq            int
+	setConfigChan  chan *setConfig
+	syncChan       chan chan error
+	timeFormat     string
+	utc            bool
+}
+
+var _ io.WriteCloser = (*FileSet)(nil)
+
+type setHeader struct {
+	header  string
+	replyTo chan bool
+}
+
+type setOnDelete struct {
+	f       func(path string)
+	replyTo chan bool
+}
+
+type setOnRotate struct {
+	f       func(closedPath, newPath string)
+	replyTo chan bool
 }
 
 type setConfig struct {
 	fileSize int
 	numFiles int
-	replyTo  chan bool
+	// cfg, if it is not nil, contains the other parameters set by Reconfigure
+	cfg     *Config
+	replyTo chan error
 }
 
 type writeRequest struct {
@@ -53,171 +188,1143 @@ type writeResponse struct {
 	err error
 }
 
+// Config contains the parameters of a FileSet
+type Config struct {
+	// LogDir is the directory of the log files. It is created if it does not exist.
+	LogDir string
+	// LogName is the prefix of the names of the log files
+	LogName string
+	// MaxFileSize is the size in bytes at which a log file is closed and a new one is started
+	MaxFileSize int
+	// MaxNumFiles is the maximum number of log files in LogDir
+	MaxNumFiles int
+	// NameTemplate is the template of the log file names. The default is DefaultNameTemplate.
+	NameTemplate string
+	// FileTimeFormat is the Go time layout of the creation time of a log file in its name, e.g.
+	// "20060102-150405" for names without colons. The default is time.RFC3339Nano. Log files
+	// are ordered by name, so the layout must sort in time order. If a log file with the name
+	// of a new file exists, e.g. because the previous file was created in the same second, the
+	// time in the name of the new file is followed by _001, _002, etc.
+	FileTimeFormat string
+	// Header is written at the start of every log file, following the file set configuration
+	Header string
+	// JSONHeader makes the file set configuration and Header at the start of every log file a
+	// single line JSON object: {"fileset":{"time":...,"maxFileSize":...,"maxNumFiles":...,"header":...}}
+	JSONHeader bool
+	// RetentionAge, if it is not 0, is the maximum age of log files. When a new log file is
+	// created the log files with a creation time, given by their names, older than RetentionAge
+	// are deleted. A file is deleted if it exceeds either MaxNumFiles or RetentionAge.
+	RetentionAge time.Duration
+	// Dedup removes a closed log file if its contents following the file set header are
+	// identical to those of the preceding log file, e.g. when a crash looping program writes
+	// the same startup log repeatedly. The removed file does not count against MaxNumFiles.
+	Dedup bool
+	// Overflow determines the behaviour of Write when the write queue is full
+	Overflow OverflowPolicy
+	// OverflowTimeout is the time a DropNewest Write waits to queue its buffer.
+	// The default is DefaultOverflowTimeout.
+	OverflowTimeout time.Duration
+	// Timeout is the time SetConfig and Close wait for the FileSet to apply the configuration
+	// or to close the current log file. The default is DefaultTimeout. WriteTimeout is the time
+	// Write waits for the write of its buffer. The default WriteTimeout of 0 waits until the
+	// write completes. A negative Timeout or WriteTimeout waits until the call completes.
+	Timeout      time.Duration
+	WriteTimeout time.Duration
+	// TimeFormat is the Go time layout of the time of the file set configuration at the start
+	// of every log file. The default is time.RFC3339Nano. UTC shows the time in UTC instead of
+	// local time. The times in the log file names are not affected.
+	TimeFormat string
+	UTC        bool
+	// MaxTotalBytes, if it is not 0, limits the total size of the log files of the FileSet. When
+	// a new log file is created the oldest files are deleted until the sizes of the remaining
+	// files plus MaxFileSize, the size of the new file, do not exceed MaxTotalBytes. A file is
+	// deleted if it exceeds either MaxNumFiles or MaxTotalBytes, so the smaller limit applies.
+	// The current file may exceed MaxFileSize by up to the size of the write that fills it.
+	MaxTotalBytes int64
+	// RotateInterval, if it is not 0, also rotates the current log file RotateInterval after it
+	// was created, e.g. 24 * time.Hour for daily log files. If RotateInterval is a multiple of a
+	// day the rotations are aligned to midnight, in UTC if UTC is set. A file is rotated by
+	// whichever of MaxFileSize and RotateInterval is reached first. An empty file is not rotated
+	// at the end of its interval; the next write starts a new file instead.
+	RotateInterval time.Duration
+	// Compress gzips every log file rotated out of the current file to <name>.gz in a separate
+	// goroutine, so that compression does not delay writes. Compressed files count against
+	// MaxNumFiles and are listed by ListLogFiles. Close waits for pending compressions.
+	Compress bool
+	// BufferSize is the size in bytes of the write buffer of the current log file. A write is
+	// buffered until the buffer is full, FlushInterval has passed, or the FileSet is flushed,
+	// synced, rotated, reconfigured or closed. The default is DefaultBufferSize. A negative
+	// BufferSize writes every Write to the log file before Write returns.
+	BufferSize    int
+	FlushInterval time.Duration
+	// Append makes Open continue the newest existing log file, instead of starting a new one, if
+	// it is smaller than MaxFileSize and, if RotateInterval is set, its interval has not ended,
+	// e.g. so that a crash looping program does not evict the history by creating a file at
+	// every start. The file set configuration and Header are written to the file again.
+	Append bool
+	// FileMode is the permission of the log files, e.g. 0640. The default is DefaultFileMode.
+	// DirMode is the permission of LogDir and its parent directories if they are created, e.g.
+	// 0750. The default is DefaultDirMode. Both are modified by the umask of the process.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// New returns a new FileSet. New panics if the log directory or the first log file cannot be
+// created. See Open.
 func New(logDir, logName string, maxFileSize, maxNumFiles int) *FileSet {
-	fmt.Fprintf(os.Stdout, "Log directory: %s\n", logDir)
-	fs := &FileSet{
-		closeChan:     make(chan chan bool, 1),
-		logDir:        logDir,
-		logName:       logName,
-		maxFileSize:   maxFileSize,
-		maxNumFiles:   maxNumFiles,
-		msgChan:       make(chan *writeRequest, 1024),
-		setConfigChan: make(chan *setConfig),
-	}
-	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
+	fs, err := Open(&Config{
+		LogDir:      logDir,
+		LogName:     logName,
+		MaxFileSize: maxFileSize,
+		MaxNumFiles: maxNumFiles,
+	})
+	if err != nil {
 		panic(err)
 	}
+	return fs
+}
+
+// Open returns a new FileSet configured by cfg after creating the log directory and opening
+// the first log file. Open returns an error if either of these fails or if cfg.NameTemplate
+// or cfg.FileTimeFormat is invalid.
+func Open(cfg *Config) (*FileSet, error) {
+	fmt.Fprintf(os.Stdout, "Log directory: %s\n", cfg.LogDir)
+	fs := newFileSet(cfg)
+	if err := checkNameTemplate(fs.nameTemplate); err != nil {
+		return nil, err
+	}
+	if err := checkFileTimeFormat(fs.fileTimeFormat); err != nil {
+		return nil, err
+	}
+	dirMode := cfg.DirMode
+	if dirMode == 0 {
+		dirMode = DefaultDirMode
+	}
+	if err := os.MkdirAll(cfg.LogDir, dirMode); err != nil {
+		return nil, fmt.Errorf("Error creating log directory %s: %s", cfg.LogDir, err)
+	}
+	// the rotation queues the existing log files for compression
+	fs.setCompress(cfg.Compress)
+	if !cfg.Append || !fs.appendNewest() {
+		if err := fs.rotate(); err != nil {
+			fs.setCompress(false)
+			return nil, err
+		}
+	}
 	go fs.run()
+	return fs, nil
+}
+
+func newFileSet(cfg *Config) *FileSet {
+	fs := &FileSet{
+		bufSize:         cfg.BufferSize,
+		closeChan:       make(chan chan error, 1),
+		dedup:           cfg.Dedup,
+		fileMode:        cfg.FileMode,
+		fileTimeFormat:  cfg.FileTimeFormat,
+		flushChan:       make(chan chan error),
+		flushInterval:   cfg.FlushInterval,
+		header:          cfg.Header,
+		headerChan:      make(chan *setHeader),
+		healthChan:      make(chan chan error),
+		statsChan:       make(chan chan *Stats),
+		jsonHeader:      cfg.JSONHeader,
+		logDir:          cfg.LogDir,
+		logName:         cfg.LogName,
+		maxFileSize:     cfg.MaxFileSize,
+		maxNumFiles:     cfg.MaxNumFiles,
+		maxTotalBytes:   cfg.MaxTotalBytes,
+		msgChan:         make(chan *writeRequest, 1024),
+		onDeleteChan:    make(chan *setOnDelete),
+		onRotateChan:    make(chan *setOnRotate),
+		nameTemplate:    cfg.NameTemplate,
+		overflow:        cfg.Overflow,
+		overflowTimeout: cfg.OverflowTimeout,
+		timeout:         cfg.Timeout,
+		writeTimeout:    cfg.WriteTimeout,
+		retentionAge:    cfg.RetentionAge,
+		rotateInterval:  cfg.RotateInterval,
+		rotateChan:      make(chan chan error),
+		setConfigChan:   make(chan *setConfig),
+		syncChan:        make(chan chan error),
+		timeFormat:      cfg.TimeFormat,
+		utc:             cfg.UTC,
+	}
+	if fs.overflowTimeout == 0 {
+		fs.overflowTimeout = DefaultOverflowTimeout
+	}
+	if fs.timeout == 0 {
+		fs.timeout = DefaultTimeout
+	}
+	if fs.nameTemplate == "" {
+		fs.nameTemplate = DefaultNameTemplate
+	}
+	if fs.fileMode == 0 {
+		fs.fileMode = DefaultFileMode
+	}
+	if fs.fileTimeFormat == "" {
+		fs.fileTimeFormat = time.RFC3339Nano
+	}
+	if fs.timeFormat == "" {
+		fs.timeFormat = time.RFC3339Nano
+	}
+	if fs.bufSize == 0 {
+		fs.bufSize = DefaultBufferSize
+	}
+	if fs.flushInterval <= 0 {
+		fs.flushInterval = DefaultFlushInterval
+	}
 	return fs
 }
 
-func (fs *FileSet) Close() {
-	reply := make(chan bool)
+// checkNameTemplate returns an error if tmpl is not a valid log file name template
+func checkNameTemplate(tmpl string) error {
+	rest := tmpl
+	for _, ph := range []string{"{name}", "{time}", "{seq}"} {
+		if strings.Count(tmpl, ph) > 1 {
+			return fmt.Errorf("Invalid log file name template %s: more than one %s", tmpl, ph)
+		}
+		rest = strings.Replace(rest, ph, "", -1)
+	}
+	switch {
+	case strings.ContainsAny(rest, "{}"):
+		return fmt.Errorf("Invalid log file name template %s: unknown placeholder", tmpl)
+	case strings.ContainsAny(rest, "*?[\\/"):
+		return fmt.Errorf("Invalid log file name template %s: invalid character", tmpl)
+	case !strings.Contains(tmpl, "{time}"):
+		return fmt.Errorf("Invalid log file name template %s: missing {time}", tmpl)
+	case strings.Contains(tmpl, "{seq}") && strings.Index(tmpl, "{seq}") < strings.Index(tmpl, "{time}"):
+		return fmt.Errorf("Invalid log file name template %s: {seq} precedes {time}", tmpl)
+	}
+	return nil
+}
+
+// CheckFileTimeFormat returns an error if layout is not a valid Config.FileTimeFormat. The
+// empty layout selects the default.
+func CheckFileTimeFormat(layout string) error {
+	if layout == "" {
+		return nil
+	}
+	return checkFileTimeFormat(layout)
+}
+
+// checkFileTimeFormat returns an error if layout is not a valid layout of the time in a log
+// file name
+func checkFileTimeFormat(layout string) error {
+	tm := time.Date(2001, 2, 3, 4, 5, 6, 700000000, time.Local)
+	s := tm.Format(layout)
+	switch {
+	case strings.ContainsAny(s, "*?[\\/"):
+		return fmt.Errorf("Invalid log file time format %s: invalid character", layout)
+	case s == tm.AddDate(1, 1, 1).Add(time.Hour+time.Minute+time.Second).Format(layout):
+		return fmt.Errorf("Invalid log file time format %s: no time", layout)
+	}
+	if _, err := time.Parse(layout, s); err != nil {
+		return fmt.Errorf("Invalid log file time format %s: %s", layout, err)
+	}
+	return nil
+}
+
+// Close writes all queued writes, closes the current log file and waits for the pending
+// compressions of rotated log files. Close returns an error if closing the file fails, or
+// ErrTimeout if it does not complete within the timeout of fs, see Config.Timeout. Closing a closed FileSet has
+// no effect and returns nil.
+func (fs *FileSet) Close() error {
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		return nil
+	}
+	fs.closed = true
+	fs.mu.Unlock()
+	reply := make(chan error, 1)
 	fs.closeChan <- reply
 	select {
-	case <-reply:
-		return
-	case <-time.After(time.Second):
-		panic("timeout")
+	case err := <-reply:
+		return err
+	case <-after(fs.timeout):
+		return ErrTimeout
 	}
 }
 
+// CurrentDiskUsage returns the total size in bytes of the log files of logName in logDir.
+func CurrentDiskUsage(logDir, logName string) (int64, error) {
+	var total int64
+	for _, fname := range ListLogFiles(logDir, logName) {
+		fi, err := os.Stat(fname)
+		if err != nil {
+			return 0, err
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+// EstimateMaxBytes returns the maximum number of bytes used by a FileSet of numFiles files
+// of fileNumBytes bytes each.
+func EstimateMaxBytes(numFiles, fileNumBytes int) int {
+	return numFiles * fileNumBytes
+}
+
+// HealthCheck returns an error if the current log file of fs can no longer be written, e.g.
+// because it has been removed or its file system has been unmounted. HealthCheck returns
+// ErrClosed if fs is closed.
+func (fs *FileSet) HealthCheck() error {
+	reply := make(chan error, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return ErrClosed
+	}
+	fs.healthChan <- reply
+	fs.mu.RUnlock()
+	return <-reply
+}
+
+// Stats contains the statistics of a FileSet returned by FileSet.Stats
+type Stats struct {
+	// CurrentFile is the path of the current log file
+	CurrentFile string
+	// CurrentFileSize is the number of bytes written to the current log file after its header,
+	// including the bytes in its write buffer. The file is rotated when it reaches MaxFileSize.
+	CurrentFileSize int
+	// MaxFileSize is the size at which the current log file is rotated
+	MaxFileSize int
+	// NumFiles is the number of log files of the FileSet on disk, including the current log file
+	NumFiles int
+	// TotalBytes is the number of bytes used by the log files of the FileSet on disk
+	TotalBytes int64
+}
+
+// Stats returns the statistics of fs, or ErrClosed if fs is closed. Stats does not flush the
+// queued or buffered writes of fs.
+func (fs *FileSet) Stats() (*Stats, error) {
+	reply := make(chan *Stats, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	fs.statsChan <- reply
+	fs.mu.RUnlock()
+	return <-reply, nil
+}
+
+func (fs *FileSet) stats() *Stats {
+	stats := &Stats{
+		CurrentFile:     fs.currentFile.Name(),
+		CurrentFileSize: fs.currentFileSize,
+		MaxFileSize:     fs.maxFileSize,
+	}
+	for _, fname := range fs.listLogFiles() {
+		// a file may be deleted or compressed while it is listed
+		if fi, err := os.Stat(fname); err == nil {
+			stats.NumFiles++
+			stats.TotalBytes += fi.Size()
+		}
+	}
+	return stats
+}
+
 // ListLogFiles returns the logfiles of logname in logDir sorted from oldest to newest
 func ListLogFiles(logDir, logName string) []string {
-	froot := filepath.Join(logDir, logName)
-	pattern := fmt.Sprintf("%s*.log", froot)
+	return ListTemplateLogFiles(logDir, logName, DefaultNameTemplate)
+}
+
+// ListTemplateLogFiles returns the logfiles of logname in logDir with names matching the name
+// template tmpl, sorted from oldest to newest, including the compressed log files with names
+// matching tmpl followed by ".gz". The <logName>-latest.log link to the current log file is
+// not listed. See DefaultNameTemplate and Config.Compress.
+func ListTemplateLogFiles(logDir, logName, tmpl string) []string {
+	pattern := filepath.Join(logDir, strings.NewReplacer(
+		"{name}", logName,
+		"{time}", "*",
+		"{seq}", "*",
+	).Replace(tmpl))
 	fs, err := filepath.Glob(pattern)
 	if err != nil {
 		panic(err)
 	}
+	compressed, _ := filepath.Glob(pattern + compressedSuffix)
+	latest := filepath.Join(logDir, logName+latestSuffix)
+	plain := make(map[string]bool, len(fs))
+	for i := 0; i < len(fs); i++ {
+		if fs[i] == latest {
+			fs = append(fs[:i], fs[i+1:]...)
+			i--
+			continue
+		}
+		plain[fs[i]] = true
+	}
+	for _, fname := range compressed {
+		// a log file being compressed is listed once
+		if !plain[strings.TrimSuffix(fname, compressedSuffix)] {
+			fs = append(fs, fname)
+		}
+	}
 	sort.Strings(fs)
 
 	return fs
 }
 
 // SetConfig sets the maximum number of log files to numfiles and
-// the maximum file size to filesize bytes.
-func (fs *FileSet) SetConfig(numFiles, fileSize int) {
-	reply := make(chan bool)
-	fs.setConfigChan <- &setConfig{
+// the maximum file size to filesize bytes. SetConfig has no effect on a closed FileSet.
+// SetConfig returns the error of the rotation to a new log file if the current file exceeds the
+// new file size, or ErrTimeout if it does not complete within the timeout of fs.
+func (fs *FileSet) SetConfig(numFiles, fileSize int) error {
+	return fs.sendConfig(&setConfig{
 		numFiles: numFiles,
 		fileSize: fileSize,
-		replyTo:  reply,
+	})
+}
+
+// Reconfigure applies the parameters of cfg that can be changed while fs is open: MaxFileSize,
+// MaxNumFiles, Compress, RotateInterval, MaxTotalBytes, FileMode, Header, JSONHeader,
+// TimeFormat and UTC. A changed RotateInterval applies to the current log file, from its
+// creation time. A changed FileMode and the file set configuration and Header apply to the log
+// files created after the change. The other fields of cfg are ignored. Reconfigure has no effect
+// on a closed FileSet and returns the same errors as SetConfig.
+func (fs *FileSet) Reconfigure(cfg *Config) error {
+	return fs.sendConfig(&setConfig{
+		numFiles: cfg.MaxNumFiles,
+		fileSize: cfg.MaxFileSize,
+		cfg:      cfg,
+	})
+}
+
+// sendConfig sends cfg to the run loop of fs and returns the error of setConfig
+func (fs *FileSet) sendConfig(cfg *setConfig) error {
+	reply := make(chan error, 1)
+	cfg.replyTo = reply
+	timeout := after(fs.timeout)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return nil
 	}
 	select {
-	case <-reply:
-	case <-time.After(time.Second):
-		panic("Timeout waiting for files to set config")
+	case fs.setConfigChan <- cfg:
+	case <-timeout:
+		fs.mu.RUnlock()
+		return ErrTimeout
 	}
+	fs.mu.RUnlock()
+	select {
+	case err := <-reply:
+		return err
+	case <-timeout:
+		return ErrTimeout
+	}
+}
+
+// SetHeader sets the header written at the start of subsequent log files to header.
+// SetHeader has no effect on a closed FileSet.
+func (fs *FileSet) SetHeader(header string) {
+	reply := make(chan bool)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return
+	}
+	fs.headerChan <- &setHeader{
+		header:  header,
+		replyTo: reply,
+	}
+	fs.mu.RUnlock()
+	<-reply
+}
+
+// Dropped returns the number of writes dropped by the overflow policy of fs.
+func (fs *FileSet) Dropped() uint64 {
+	return atomic.LoadUint64(&fs.dropped)
+}
+
+// Rotate closes the current log file after writing all queued writes and starts a new one.
+// Rotate returns ErrClosed if fs is closed.
+func (fs *FileSet) Rotate() error {
+	reply := make(chan error, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return ErrClosed
+	}
+	fs.rotateChan <- reply
+	fs.mu.RUnlock()
+	return <-reply
+}
+
+/*
+OnRotate sets the function f that is called every time the current log file has been closed
+and the next log file created, e.g. to upload the closed file, with the paths of both files.
+It replaces the function of a previous call. OnRotate(nil) removes the function.
+
+closedPath is empty if the closed file was removed because it was empty or, see Config.Dedup,
+a duplicate. If Config.Compress is set the closed file is replaced by closedPath + ".gz" in the
+background.
+
+f and the function of OnDelete are called one at a time by the goroutine of fs, which waits
+for them, so they should return quickly and must not call the methods of fs.
+*/
+func (fs *FileSet) OnRotate(f func(closedPath, newPath string)) {
+	reply := make(chan bool, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return
+	}
+	fs.onRotateChan <- &setOnRotate{f: f, replyTo: reply}
+	fs.mu.RUnlock()
+	<-reply
+}
+
+// OnDelete sets the function f that is called with the path of every log file fs deletes,
+// whether it exceeds the retention limits, is empty or is a duplicate. It replaces the
+// function of a previous call. OnDelete(nil) removes the function. See OnRotate.
+func (fs *FileSet) OnDelete(f func(path string)) {
+	reply := make(chan bool, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return
+	}
+	fs.onDeleteChan <- &setOnDelete{f: f, replyTo: reply}
+	fs.mu.RUnlock()
+	<-reply
 }
 
+// Flush writes all queued and buffered writes to the current log file. Flush returns ErrClosed
+// if fs is closed.
+func (fs *FileSet) Flush() error {
+	reply := make(chan error, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return ErrClosed
+	}
+	fs.flushChan <- reply
+	fs.mu.RUnlock()
+	return <-reply
+}
+
+// Sync commits the current log file to stable storage after writing all queued and buffered
+// writes.
+func (fs *FileSet) Sync() error {
+	reply := make(chan error, 1)
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return ErrClosed
+	}
+	fs.syncChan <- reply
+	fs.mu.RUnlock()
+	return <-reply
+}
+
+// Write writes buf to the current log file, or to its write buffer, see Config.BufferSize. If
+// the write queue is full Write blocks or drops a write according to the overflow policy of fs.
+// Write returns ErrDropped if buf was dropped, ErrClosed if fs is closed, and the number of bytes
+// written with the error if writing the log file or rotating to the next one fails. Write
+// returns ErrTimeout if the write does not complete within Config.WriteTimeout. An error
+// writing buffered writes to the log file is returned by the next Write.
 func (fs *FileSet) Write(buf []byte) (int, error) {
-	reply := make(chan *writeResponse)
-	fs.msgChan <- &writeRequest{
+	req := &writeRequest{
 		msg:   buf,
-		reply: reply,
+		reply: make(chan *writeResponse, 1),
+	}
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	queued := fs.enqueue(req)
+	fs.mu.RUnlock()
+	if !queued {
+		atomic.AddUint64(&fs.dropped, 1)
+		return 0, ErrDropped
 	}
 	select {
-	case <-time.After(time.Second):
-		panic("Timeout")
-	case rep := <-reply:
+	case rep := <-req.reply:
 		return rep.n, rep.err
+	case <-after(fs.writeTimeout):
+		return 0, ErrTimeout
 	}
 }
 
+// WriteString writes s to the current log file. See Write.
+func (fs *FileSet) WriteString(s string) (int, error) {
+	return fs.Write([]byte(s))
+}
+
 /*** FileSet ***/
 
-func (fs *FileSet) close() {
+// enqueue queues req according to the overflow policy of fs and returns false if req was dropped.
+func (fs *FileSet) enqueue(req *writeRequest) bool {
+	switch fs.overflow {
+	case DropNewest:
+		select {
+		case fs.msgChan <- req:
+			return true
+		case <-time.After(fs.overflowTimeout):
+			return false
+		}
+	case DropOldest:
+		for {
+			select {
+			case fs.msgChan <- req:
+				return true
+			default:
+			}
+			select {
+			case old := <-fs.msgChan:
+				atomic.AddUint64(&fs.dropped, 1)
+				old.reply <- &writeResponse{0, ErrDropped}
+			default:
+			}
+		}
+	}
+	fs.msgChan <- req
+	return true
+}
+
+func (fs *FileSet) close() error {
 	close(fs.msgChan)
 	for msg := range fs.msgChan {
-		fs.log(msg.msg)
+		msg.reply <- fs.log(msg.msg)
 	}
 
-	fname := fs.currentFile.Name()
-	if fs.currentFileSize < 1 {
-		fs.rmFile(fname)
+	var err error
+	// currentFile is nil if the last rotation failed
+	if fs.currentFile != nil {
+		fname := fs.currentFile.Name()
+		if fs.currentFileSize < 1 {
+			fs.rmFile(fname)
+			os.Remove(filepath.Join(fs.logDir, fs.logName+latestSuffix))
+		}
+		err = fs.closeFile()
+	}
+	if fs.rotateTimer != nil {
+		fs.rotateTimer.Stop()
 	}
+	fs.setCompress(false)
+	return err
+}
 
-	fs.currentFile.Close()
+// closeFile writes the buffered writes to the current log file, closes it and sets it to nil
+func (fs *FileSet) closeFile() error {
+	err := fs.flushBuffer()
+	if err1 := fs.currentFile.Close(); err == nil {
+		err = err1
+	}
+	fs.currentFile, fs.buf = nil, nil
+	return err
+}
+
+// flushBuffer writes the buffered writes of fs to the current log file
+func (fs *FileSet) flushBuffer() error {
+	if fs.flushTimer != nil {
+		fs.flushTimer.Stop()
+		fs.flushTimer = nil
+	}
+	if fs.buf == nil {
+		return nil
+	}
+	return fs.buf.Flush()
+}
+
+// bufferFlush returns the channel of the timer of the buffered writes, or nil if no writes are
+// buffered
+func (fs *FileSet) bufferFlush() <-chan time.Time {
+	if fs.flushTimer == nil {
+		return nil
+	}
+	return fs.flushTimer.C
+}
+
+// out returns the writer of the current log file: its write buffer, or the file if writes are
+// not buffered
+func (fs *FileSet) out() io.Writer {
+	if fs.buf != nil {
+		return fs.buf
+	}
+	return fs.currentFile
+}
+
+// flush writes the queued writes of fs
+func (fs *FileSet) flush() {
+	for n := len(fs.msgChan); n > 0; n-- {
+		msg := <-fs.msgChan
+		msg.reply <- fs.log(msg.msg)
+	}
 }
 
 func (fs *FileSet) listLogFiles() []string {
-	return ListLogFiles(fs.logDir, fs.logName)
+	return ListTemplateLogFiles(fs.logDir, fs.logName, fs.nameTemplate)
 }
 
 func (fs *FileSet) log(buf []byte) *writeResponse {
-	n, err := fs.currentFile.Write(buf)
+	if fs.rotateDue {
+		if err := fs.rotate(); err != nil {
+			return &writeResponse{0, err}
+		}
+	}
+	n, err := fs.out().Write(buf)
 	if err == nil {
 		fs.currentFileSize += len(buf)
 		if fs.currentFileSize >= fs.maxFileSize {
-			fs.rotate()
+			err = fs.rotate()
+		} else if fs.flushTimer == nil && fs.buf != nil && fs.buf.Buffered() > 0 {
+			fs.flushTimer = time.NewTimer(fs.flushInterval)
 		}
 	}
 	return &writeResponse{n, err}
 }
 
+// healthCheck returns an error if the current log file is not open, cannot be written or is
+// no longer the file with its name in the log directory.
+func (fs *FileSet) healthCheck() error {
+	if fs.currentFile == nil {
+		return errors.New("Error checking log file: no open log file")
+	}
+	fname := fs.currentFile.Name()
+	open, err := fs.currentFile.Stat()
+	if err != nil {
+		return fmt.Errorf("Error checking log file %s: %s", fname, err)
+	}
+	if _, err := fs.currentFile.Write(nil); err != nil {
+		return fmt.Errorf("Error writing log file %s: %s", fname, err)
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return fmt.Errorf("Error checking log file %s: %s", fname, err)
+	}
+	if !os.SameFile(open, fi) {
+		return fmt.Errorf("Error checking log file %s: the file has been replaced", fname)
+	}
+	return nil
+}
+
+// headerPrefix is the start of the first line of a log file
+const headerPrefix = "File set configuration @ "
+
+// headerLines is the number of lines of log file header
+const headerLines = 3
+
+// jsonHeaderPrefix is the start of the first line of a log file with a JSON header
+const jsonHeaderPrefix = `{"fileset":`
+
+type jsonHeader struct {
+	Time        string `json:"time"`
+	MaxFileSize int    `json:"maxFileSize"`
+	MaxNumFiles int    `json:"maxNumFiles"`
+	Header      string `json:"header,omitempty"`
+}
+
 func (fs *FileSet) logConfig() {
-	fmt.Fprintf(fs.currentFile, "File set configuration @ %s\n", time.Now().Format(time.RFC3339Nano))
+	if fs.jsonHeader {
+		buf, err := json.Marshal(map[string]*jsonHeader{"fileset": {
+			Time:        fs.now(),
+			MaxFileSize: fs.maxFileSize,
+			MaxNumFiles: fs.maxNumFiles,
+			Header:      strings.TrimRight(fs.header, "\n"),
+		}})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(fs.currentFile, "%s\n", buf)
+		return
+	}
+	fmt.Fprintf(fs.currentFile, headerPrefix+"%s\n", fs.now())
 	fmt.Fprintf(fs.currentFile, "Maximum file size %d bytes\n", fs.maxFileSize)
 	fmt.Fprintf(fs.currentFile, "Maximum %d files\n", fs.maxNumFiles)
+	if fs.header != "" {
+		fmt.Fprint(fs.currentFile, strings.TrimRight(fs.header, "\n")+"\n")
+	}
 }
 
-func (fs *FileSet) newFile() {
-	tm := time.Now().Format(time.RFC3339Nano)
-	fname := filepath.Join(fs.logDir,
-		fmt.Sprintf("%s_%s.log", fs.logName, tm))
+// now returns the current time in the time format of fs
+func (fs *FileSet) now() string {
+	tm := time.Now()
+	if fs.utc {
+		tm = tm.UTC()
+	}
+	return tm.Format(fs.timeFormat)
+}
 
-	var err error
-	// fs.currentFile, err = os.Create(filepath.Join(fs.logDir, fname))
-	fs.currentFile, err = os.Create(fname)
+// after returns a channel that receives the time after d, or nil, which never receives, if d
+// is not positive
+func after(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+	return time.After(d)
+}
+
+// newFile creates the next log file. The current log file, if any, is closed first so that a
+// FileSet never has more than one open file descriptor.
+func (fs *FileSet) newFile() error {
+	if fs.currentFile != nil {
+		fs.closeFile()
+	}
+	now := time.Now()
+	fs.seq++
+	tm := now.Format(fs.fileTimeFormat)
+	for n := 1; ; n++ {
+		fname := filepath.Join(fs.logDir, strings.NewReplacer(
+			"{name}", fs.logName,
+			"{time}", tm,
+			"{seq}", fmt.Sprintf("%06d", fs.seq),
+		).Replace(fs.nameTemplate))
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.fileMode)
+		if os.IsExist(err) {
+			// a file was created within the resolution of fs.fileTimeFormat. The suffix
+			// sorts the new file after it.
+			tm = fmt.Sprintf("%s_%03d", now.Format(fs.fileTimeFormat), n)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fs.setCurrentFile(f, now)
+		return nil
+	}
+}
+
+// appendNewest opens the newest log file of fs for appending and returns true, or returns
+// false if there is no log file that can be continued, see Config.Append.
+func (fs *FileSet) appendNewest() bool {
+	logFiles := fs.listLogFiles()
+	if len(logFiles) == 0 {
+		return false
+	}
+	fname := logFiles[len(logFiles)-1]
+	if strings.HasSuffix(fname, compressedSuffix) {
+		return false
+	}
+	fi, err := os.Stat(fname)
+	if err != nil || fi.Size() >= int64(fs.maxFileSize) {
+		return false
+	}
+	created, ok := fs.fileTime(fname)
+	if !ok {
+		return false
+	}
+	if fs.rotateInterval > 0 && !time.Now().Before(fs.nextRotation(created)) {
+		return false
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, fs.fileMode)
 	if err != nil {
-		panic(err)
+		// a new file is created instead
+		return false
 	}
+	fs.currentFileSize = int(fi.Size())
+	fs.setCurrentFile(f, created)
+	return true
+}
+
+// setCurrentFile makes f, created at the time created, the current log file of fs and writes
+// the file set configuration to it
+func (fs *FileSet) setCurrentFile(f *os.File, created time.Time) {
+	fs.currentFile, fs.created = f, created
+	if fs.bufSize > 0 {
+		fs.buf = bufio.NewWriterSize(fs.currentFile, fs.bufSize)
+	}
+	fs.startRotateTimer(created)
+	fs.linkLatest(f.Name())
 
 	fs.logConfig()
 }
 
+// linkLatest points the <logName>-latest.log symbolic link of fs to the log file fname. The link
+// is replaced atomically. linkLatest has no effect on platforms without symbolic links.
+func (fs *FileSet) linkLatest(fname string) {
+	link := filepath.Join(fs.logDir, fs.logName+latestSuffix)
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	// the relative target keeps the link valid if the log directory is moved
+	if err := os.Symlink(filepath.Base(fname), tmp); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// startRotateTimer starts the timer of the rotation at the end of the interval of the current
+// log file, created at the time created
+func (fs *FileSet) startRotateTimer(created time.Time) {
+	fs.rotateDue = false
+	if fs.rotateTimer != nil {
+		fs.rotateTimer.Stop()
+		fs.rotateTimer = nil
+	}
+	if fs.rotateInterval <= 0 {
+		return
+	}
+	fs.rotateTimer = time.NewTimer(time.Until(fs.nextRotation(created)))
+}
+
+// nextRotation returns the end of the interval of a log file created at tm: tm + RotateInterval,
+// or, if RotateInterval is a multiple of a day, the midnight RotateInterval after the start of
+// the day of tm.
+func (fs *FileSet) nextRotation(tm time.Time) time.Time {
+	const day = 24 * time.Hour
+	if fs.rotateInterval%day != 0 {
+		return tm.Add(fs.rotateInterval)
+	}
+	if fs.utc {
+		tm = tm.UTC()
+	}
+	midnight := time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, tm.Location())
+	// AddDate keeps midnight across daylight saving time changes
+	return midnight.AddDate(0, 0, int(fs.rotateInterval/day))
+}
+
+// rotateTick returns the channel of the timer of the rotation at the end of the interval of
+// the current log file, or nil if there is none
+func (fs *FileSet) rotateTick() <-chan time.Time {
+	if fs.rotateTimer == nil {
+		return nil
+	}
+	return fs.rotateTimer.C
+}
+
 func (fs *FileSet) rmFile(fname string) {
-	if err := os.Remove(fname); err != nil {
+	if err := fs.remove(fname); err != nil {
 		panic(err)
 	}
 }
 
-func (fs *FileSet) rotate() {
+// remove deletes the log file fname and calls the OnDelete function of fs. A file that does not
+// exist, e.g. because it has been replaced by its compressed file, is ignored.
+func (fs *FileSet) remove(fname string) error {
+	if err := os.Remove(fname); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fs.onDelete != nil {
+		fs.onDelete(fname)
+	}
+	return nil
+}
+
+func (fs *FileSet) rotate() error {
+	closed := ""
+	// the first log file of Open does not replace a file
+	rotated := fs.currentFile != nil
 	if fs.currentFile != nil {
-		fs.currentFile.Close()
+		closed = fs.currentFile.Name()
+		empty := fs.currentFileSize < 1
+		fs.closeFile()
+		if empty {
+			// an empty log file is removed as by Close
+			fs.rmFile(closed)
+			closed = ""
+		}
 	}
 	logFiles := fs.listLogFiles()
+	if fs.dedup {
+		var err error
+		var removed string
+		if logFiles, removed, err = dedup(logFiles, closed); err != nil {
+			return err
+		}
+		if removed != "" {
+			if fs.onDelete != nil {
+				fs.onDelete(removed)
+			}
+			if removed == closed {
+				closed = ""
+			}
+		}
+	}
 	delete := len(logFiles) - fs.maxNumFiles + 1
 	for i := 0; i < delete; i++ {
-		fs.rmFile(logFiles[i])
+		// a file may have been replaced by its compressed file since it was listed
+		if err := fs.remove(logFiles[i]); err != nil {
+			return err
+		}
+	}
+	if delete < 0 {
+		delete = 0
+	}
+	logFiles = logFiles[delete:]
+	if fs.maxTotalBytes > 0 {
+		var err error
+		if logFiles, err = fs.deleteExcessBytes(logFiles); err != nil {
+			return err
+		}
+	}
+	if fs.compressChan != nil {
+		fs.queueCompress(logFiles)
 	}
-	fs.newFile()
 	fs.currentFileSize = 0
+	if err := fs.newFile(); err != nil {
+		return err
+	}
+	if rotated && fs.onRotate != nil {
+		fs.onRotate(closed, fs.currentFile.Name())
+	}
+	return fs.deleteExpired()
+}
+
+// deleteExcessBytes deletes the oldest of logFiles, sorted from oldest to newest, until their
+// total size plus the maximum size of a new log file does not exceed the maximum total size of
+// fs, and returns the remaining files.
+func (fs *FileSet) deleteExcessBytes(logFiles []string) ([]string, error) {
+	sizes := make([]int64, len(logFiles))
+	total := int64(fs.maxFileSize)
+	for i, fname := range logFiles {
+		fi, err := os.Stat(fname)
+		if err != nil {
+			// a file may have been replaced by its compressed file since it was listed
+			continue
+		}
+		sizes[i] = fi.Size()
+		total += sizes[i]
+	}
+	for len(logFiles) > 0 && total > fs.maxTotalBytes {
+		if err := fs.remove(logFiles[0]); err != nil {
+			return nil, err
+		}
+		total -= sizes[0]
+		logFiles, sizes = logFiles[1:], sizes[1:]
+	}
+	return logFiles, nil
+}
+
+// deleteExpired deletes the log files older than the retention age of fs, except the current file
+func (fs *FileSet) deleteExpired() error {
+	if fs.retentionAge <= 0 {
+		return nil
+	}
+	expiry := time.Now().Add(-fs.retentionAge)
+	for _, fname := range fs.listLogFiles() {
+		if fname == fs.currentFile.Name() {
+			continue
+		}
+		if tm, ok := fs.fileTime(fname); ok && tm.Before(expiry) {
+			if err := fs.remove(fname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileTime returns the creation time in the name of the log file fname and true, or false if
+// fname does not contain a time.
+func (fs *FileSet) fileTime(fname string) (time.Time, bool) {
+	prefix := strings.Replace(fs.nameTemplate[:strings.Index(fs.nameTemplate, "{time}")],
+		"{name}", fs.logName, -1)
+	name := filepath.Base(fname)
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, false
+	}
+	name = name[len(prefix):]
+	// The time may have a variable number of fractional digits and is followed by the rest of
+	// the file name, so the longest time prefix of name is the time.
+	for end := len(name); end > 0; end-- {
+		if tm, err := time.ParseInLocation(fs.fileTimeFormat, name[:end], time.Local); err == nil {
+			return tm, true
+		}
+	}
+	return time.Time{}, false
 }
 
 func (fs *FileSet) run() {
-	fs.rotate()
 	for {
 		select {
 		case done := <-fs.closeChan:
-			fs.close()
-			done <- true
+			done <- fs.close()
 			return
 		case cfg := <-fs.setConfigChan:
-			fs.setConfig(cfg)
-			cfg.replyTo <- true
+			cfg.replyTo <- fs.setConfig(cfg)
 		case msg := <-fs.msgChan:
 			msg.reply <- fs.log(msg.msg)
+		case <-fs.bufferFlush():
+			fs.flushTimer = nil
+			// an error is returned by the next write
+			fs.buf.Flush()
+		case replyTo := <-fs.flushChan:
+			fs.flush()
+			replyTo <- fs.flushBuffer()
+		case msg := <-fs.onDeleteChan:
+			fs.onDelete = msg.f
+			msg.replyTo <- true
+		case msg := <-fs.onRotateChan:
+			fs.onRotate = msg.f
+			msg.replyTo <- true
+		case msg := <-fs.headerChan:
+			fs.header = msg.header
+			msg.replyTo <- true
+		case replyTo := <-fs.healthChan:
+			fs.flushBuffer()
+			replyTo <- fs.healthCheck()
+		case replyTo := <-fs.statsChan:
+			replyTo <- fs.stats()
+		case <-fs.rotateTick():
+			fs.rotateTimer = nil
+			fs.flush()
+			if fs.currentFileSize < 1 {
+				fs.rotateDue = true
+			} else if err := fs.rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error rotating log files: %s\n", err)
+			}
+		case replyTo := <-fs.rotateChan:
+			fs.flush()
+			replyTo <- fs.rotate()
+		case replyTo := <-fs.syncChan:
+			fs.flush()
+			if err := fs.flushBuffer(); err != nil {
+				replyTo <- err
+				break
+			}
+			replyTo <- fs.currentFile.Sync()
 		}
 	}
 }
 
-func (fs *FileSet) setConfig(cfg *setConfig) {
+func (fs *FileSet) setConfig(cfg *setConfig) error {
+	fs.flushBuffer()
 	fs.maxFileSize = cfg.fileSize
 	fs.maxNumFiles = cfg.numFiles
+	if cfg.cfg != nil {
+		fs.setCompress(cfg.cfg.Compress)
+		fs.header, fs.jsonHeader = cfg.cfg.Header, cfg.cfg.JSONHeader
+		fs.timeFormat = cfg.cfg.TimeFormat
+		if fs.timeFormat == "" {
+			fs.timeFormat = time.RFC3339Nano
+		}
+		// UTC aligns the daily rotations to midnight in UTC
+		if cfg.cfg.RotateInterval != fs.rotateInterval || cfg.cfg.UTC != fs.utc {
+			fs.rotateInterval, fs.utc = cfg.cfg.RotateInterval, cfg.cfg.UTC
+			if fs.currentFile != nil {
+				fs.startRotateTimer(fs.created)
+			}
+		}
+		fs.maxTotalBytes = cfg.cfg.MaxTotalBytes
+		fs.fileMode = cfg.cfg.FileMode
+		if fs.fileMode == 0 {
+			fs.fileMode = DefaultFileMode
+		}
+	}
 	if fs.currentFileSize > fs.maxFileSize {
-		fs.rotate()
+		return fs.rotate()
+	}
+	if fs.maxTotalBytes > 0 && fs.currentFile != nil {
+		// the current file counts as a new file of the maximum size
+		var closed []string
+		for _, fname := range fs.listLogFiles() {
+			if fname != fs.currentFile.Name() {
+				closed = append(closed, fname)
+			}
+		}
+		if _, err := fs.deleteExcessBytes(closed); err != nil {
+			return err
+		}
 	}
 	// fs.logConfig()
+	return nil
 }
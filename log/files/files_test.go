@@ -2,7 +2,12 @@ package files
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -19,3 +24,1260 @@ func TestFiles1(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeReaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := func(ms int) string {
+		return t0.Add(time.Duration(ms) * time.Millisecond).Format(time.RFC3339Nano)
+	}
+	logs := map[string]string{
+		"a_1.log": "header a\n" +
+			ts(0) + " a0\n" +
+			ts(20) + " a20\n  trace a20\n",
+		"a_2.log": "header a2\n" +
+			ts(30) + " a30\n",
+		"b_1.log": ts(10) + " b10\n" +
+			ts(25) + " b25\n  trace b25\n" +
+			ts(40) + " b40",
+	}
+	for fname, data := range logs {
+		if err := ioutil.WriteFile(filepath.Join(dir, fname), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rdr, err := MergeReaders(dir, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "header a\n" +
+		ts(0) + " a0\n" +
+		ts(10) + " b10\n" +
+		ts(20) + " a20\n  trace a20\nheader a2\n" +
+		ts(25) + " b25\n  trace b25\n" +
+		ts(30) + " a30\n" +
+		ts(40) + " b40\n"
+	if string(data) != exp {
+		t.Errorf("got:\n%s\nexpected:\n%s", data, exp)
+	}
+
+	// a layout with spaces and JSON lines
+	const layout = "2006-01-02 15:04:05.000"
+	ts = func(ms int) string {
+		return t0.Add(time.Duration(ms) * time.Millisecond).Format(layout)
+	}
+	js := func(ms int, msg string) string {
+		return fmt.Sprintf(`{"time":%q,"level":"INFO","msg":%q}`+"\n", ts(ms), msg)
+	}
+	logs = map[string]string{
+		"text_1.log": ts(0) + " [INFO] t0\n" +
+			ts(20) + " [INFO] t20\n  trace t20\n",
+		"json_1.log": `{"fileset":{"time":"header"}}` + "\n" +
+			js(10, "j10") +
+			js(30, "j30"),
+	}
+	for fname, data := range logs {
+		if err := ioutil.WriteFile(filepath.Join(dir, fname), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rdr, err = MergeReadersLayout(dir, []string{"text", "json"}, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	if data, err = ioutil.ReadAll(rdr); err != nil {
+		t.Fatal(err)
+	}
+	exp = `{"fileset":{"time":"header"}}` + "\n" +
+		ts(0) + " [INFO] t0\n" +
+		js(10, "j10") +
+		ts(20) + " [INFO] t20\n  trace t20\n" +
+		js(30, "j30")
+	if string(data) != exp {
+		t.Errorf("got:\n%s\nexpected:\n%s", data, exp)
+	}
+}
+
+func TestCurrentDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(dir, "usage", 1000, 3)
+	for i := 0; i < 10; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	var exp int64
+	for _, fname := range ListLogFiles(dir, "usage") {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp += int64(len(data))
+	}
+	usage, err := CurrentDiskUsage(dir, "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != exp || usage < 110 {
+		t.Errorf("usage %d, expected %d", usage, exp)
+	}
+	if EstimateMaxBytes(3, 1000) != 3000 {
+		t.Fail()
+	}
+}
+
+// fillQueue fills the write queue of fs, which has no consumer, and returns the queued requests
+func fillQueue(fs *FileSet) []*writeRequest {
+	reqs := make([]*writeRequest, cap(fs.msgChan))
+	for i := range reqs {
+		reqs[i] = &writeRequest{
+			msg:   []byte(fmt.Sprintf("%d\n", i)),
+			reply: make(chan *writeResponse, 1),
+		}
+		fs.msgChan <- reqs[i]
+	}
+	return reqs
+}
+
+// drainQueue replies to all queued requests of fs and returns the last one
+func drainQueue(fs *FileSet) *writeRequest {
+	var last *writeRequest
+	for len(fs.msgChan) > 0 {
+		last = <-fs.msgChan
+		last.reply <- &writeResponse{len(last.msg), nil}
+	}
+	return last
+}
+
+func TestOverflowBlock(t *testing.T) {
+	fs := newFileSet(&Config{Overflow: Block})
+	fillQueue(fs)
+	done := make(chan int)
+	go func() {
+		n, _ := fs.Write([]byte("blocked\n"))
+		done <- n
+	}()
+	select {
+	case <-done:
+		t.Fatal("Write did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Make room for the blocked write and reply to it
+	<-fs.msgChan
+	for len(fs.msgChan) < cap(fs.msgChan) {
+		time.Sleep(time.Millisecond)
+	}
+	if last := drainQueue(fs); string(last.msg) != "blocked\n" {
+		t.Errorf("last queued write %q", last.msg)
+	}
+	if n := <-done; n != len("blocked\n") || fs.Dropped() != 0 {
+		t.Errorf("n=%d dropped=%d", n, fs.Dropped())
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	fs := newFileSet(&Config{Overflow: DropNewest, OverflowTimeout: 10 * time.Millisecond})
+	fillQueue(fs)
+	for i := 1; i <= 3; i++ {
+		if n, err := fs.Write([]byte("dropped\n")); n != 0 || err != ErrDropped {
+			t.Errorf("Write returned %d, %v", n, err)
+		}
+		if fs.Dropped() != uint64(i) {
+			t.Errorf("dropped %d, expected %d", fs.Dropped(), i)
+		}
+	}
+	if len(fs.msgChan) != cap(fs.msgChan) {
+		t.Errorf("queue length %d", len(fs.msgChan))
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	fs := newFileSet(&Config{Overflow: DropOldest})
+	reqs := fillQueue(fs)
+	done := make(chan error)
+	go func() {
+		_, err := fs.Write([]byte("newest\n"))
+		done <- err
+	}()
+	select {
+	case rep := <-reqs[0].reply:
+		if rep.err != ErrDropped {
+			t.Errorf("oldest write returned %v", rep.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oldest write was not dropped")
+	}
+	if last := drainQueue(fs); string(last.msg) != "newest\n" {
+		t.Errorf("last queued write %q", last.msg)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if fs.Dropped() != 1 {
+		t.Errorf("dropped %d", fs.Dropped())
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const tmpl = "{name}-{time}-{seq}.txt"
+	fs, err := Open(&Config{
+		LogDir:       dir,
+		LogName:      "tmpl",
+		MaxFileSize:  100,
+		MaxNumFiles:  3,
+		NameTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 rotations
+	for i := 0; i < 45; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	logFiles := ListTemplateLogFiles(dir, "tmpl", tmpl)
+	if len(logFiles) != 3 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for _, fname := range logFiles {
+		if !strings.HasPrefix(filepath.Base(fname), "tmpl-") || !strings.HasSuffix(fname, ".txt") {
+			t.Errorf("invalid file name %s", fname)
+		}
+	}
+	if !strings.HasSuffix(logFiles[2], "-000005.txt") {
+		t.Errorf("newest file %s", logFiles[2])
+	}
+	if len(ListLogFiles(dir, "tmpl")) != 0 {
+		t.Error("ListLogFiles matched files of a custom template")
+	}
+
+	for _, tmpl := range []string{"{name}.log", "{name}_{seq}_{time}.log", "{name}_{time}_{id}.log", "{name}/{time}"} {
+		if _, err := Open(&Config{LogDir: dir, LogName: "bad", NameTemplate: tmpl}); err == nil {
+			t.Errorf("expected error for template %s", tmpl)
+		}
+	}
+}
+
+func TestLatestLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the glob of the template matches the link name
+	const tmpl = "{name}-{time}.log"
+	fs, err := Open(&Config{
+		LogDir:       dir,
+		LogName:      "latest",
+		MaxFileSize:  100,
+		MaxNumFiles:  3,
+		NameTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 rotations
+	for i := 0; i < 45; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	logFiles := ListTemplateLogFiles(dir, "latest", tmpl)
+	if len(logFiles) != 3 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	link := filepath.Join(dir, "latest-latest.log")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Base(logFiles[2]) {
+		t.Errorf("link to %s, newest file %s", target, logFiles[2])
+	}
+	fs.Close()
+}
+
+func TestHeaderTimeFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := Open(&Config{
+		LogDir:      dir,
+		LogName:     "timefmt",
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+		TimeFormat:  "2006-01-02 15:04 MST",
+		UTC:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("msg\n"))
+	fs.Close()
+
+	logFiles := ListLogFiles(dir, "timefmt")
+	if len(logFiles) != 1 {
+		t.Fatalf("%d log files", len(logFiles))
+	}
+	buf, err := ioutil.ReadFile(logFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.SplitN(string(buf), "\n", 2)[0]
+	tm, err := time.Parse(headerPrefix+"2006-01-02 15:04 MST", line)
+	if err != nil || tm.Location() != time.UTC {
+		t.Errorf("header %q: %v", line, err)
+	}
+
+	// the layout set by Reconfigure applies to the next log file
+	fs, err = Open(&Config{
+		LogDir:      dir,
+		LogName:     "reconfigure",
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Reconfigure(&Config{
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+		Header:      "reconfigured",
+		TimeFormat:  "2006-01-02 15:04 MST",
+		UTC:         true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("msg\n"))
+	fs.Rotate()
+	fs.Write([]byte("msg\n"))
+	fs.Close()
+	logFiles = ListLogFiles(dir, "reconfigure")
+	if len(logFiles) != 2 {
+		t.Fatalf("%d log files", len(logFiles))
+	}
+	if buf, err = ioutil.ReadFile(logFiles[1]); err != nil {
+		t.Fatal(err)
+	}
+	line = strings.SplitN(string(buf), "\n", 2)[0]
+	if tm, err := time.Parse(headerPrefix+"2006-01-02 15:04 MST", line); err != nil || tm.Location() != time.UTC {
+		t.Errorf("header %q after Reconfigure: %v", line, err)
+	}
+	if !strings.Contains(string(buf), "reconfigured\n") {
+		t.Errorf("no Header after Reconfigure:\n%s", buf)
+	}
+}
+
+func TestBufferedWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// contains returns true if the log file of name contains msg
+	contains := func(name, msg string) bool {
+		logFiles := ListLogFiles(dir, name)
+		if len(logFiles) != 1 {
+			t.Fatalf("%d log files of %s", len(logFiles), name)
+		}
+		buf, err := ioutil.ReadFile(logFiles[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(string(buf), msg)
+	}
+
+	fs, err := Open(&Config{
+		LogDir:        dir,
+		LogName:       "buffered",
+		MaxFileSize:   1000,
+		MaxNumFiles:   2,
+		FlushInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("flushed\n"))
+	if contains("buffered", "flushed") {
+		t.Error("write not buffered")
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !contains("buffered", "flushed\n") {
+		t.Error("write not flushed by Flush")
+	}
+	fs.Write([]byte("timed\n"))
+	time.Sleep(200 * time.Millisecond)
+	if !contains("buffered", "timed\n") {
+		t.Error("write not flushed after the flush interval")
+	}
+	fs.Close()
+
+	fs, err = Open(&Config{
+		LogDir:      dir,
+		LogName:     "unbuffered",
+		MaxFileSize: 1000,
+		MaxNumFiles: 2,
+		BufferSize:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fs.Write([]byte("unbuffered\n"))
+	if !contains("unbuffered", "unbuffered\n") {
+		t.Error("unbuffered write not written")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := Open(&Config{
+		LogDir:      dir,
+		LogName:     "gz",
+		MaxFileSize: 100,
+		MaxNumFiles: 4,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a rotation every 2 lines
+	for i := 0; i < 20; i++ {
+		if _, err := fmt.Fprintf(fs, "line %02d ......................................\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logFiles := ListLogFiles(dir, "gz")
+	if len(logFiles) != 4 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for i, fname := range logFiles {
+		if compressed := strings.HasSuffix(fname, ".log.gz"); compressed != (i < 3) {
+			t.Errorf("log file %d: %s", i, fname)
+		}
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("temporary files %v", tmp)
+	}
+	rdr, err := MergeReaders(dir, []string{"gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 12; i < 20; i++ {
+		if !strings.Contains(string(data), fmt.Sprintf("line %02d ", i)) {
+			t.Errorf("line %d missing from log:\n%s", i, data)
+		}
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := Open(&Config{
+		LogDir:         dir,
+		LogName:        "interval",
+		MaxFileSize:    1000,
+		MaxNumFiles:    10,
+		RotateInterval: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("first\n"))
+	// the first file is rotated after 100ms, the empty second file is not rotated after 200ms
+	time.Sleep(250 * time.Millisecond)
+	if logFiles := ListLogFiles(dir, "interval"); len(logFiles) != 2 {
+		t.Errorf("%d log files after 250ms: %v", len(logFiles), logFiles)
+	}
+	// the write replaces the empty file by a new file
+	fs.Write([]byte("second\n"))
+	fs.Close()
+	logFiles := ListLogFiles(dir, "interval")
+	if len(logFiles) != 2 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for i, exp := range []string{"first\n", "second\n"} {
+		if buf, err := ioutil.ReadFile(logFiles[i]); err != nil || !strings.HasSuffix(string(buf), exp) {
+			t.Errorf("log file %d: %q, %v", i, buf, err)
+		}
+	}
+
+	// an interval set by Reconfigure rotates the current file from its creation time
+	fs, err = Open(&Config{
+		LogDir:      dir,
+		LogName:     "reconfigure",
+		MaxFileSize: 1000,
+		MaxNumFiles: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("first\n"))
+	time.Sleep(100 * time.Millisecond)
+	if err := fs.Reconfigure(&Config{
+		MaxFileSize:    1000,
+		MaxNumFiles:    10,
+		RotateInterval: 50 * time.Millisecond,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	fs.Write([]byte("second\n"))
+	fs.Close()
+	if logFiles := ListLogFiles(dir, "reconfigure"); len(logFiles) != 2 {
+		t.Errorf("%d log files after Reconfigure: %v", len(logFiles), logFiles)
+	}
+
+	tm := time.Date(2020, 3, 7, 10, 30, 0, 0, time.Local)
+	for d, exp := range map[time.Duration]time.Time{
+		time.Hour:      tm.Add(time.Hour),
+		24 * time.Hour: time.Date(2020, 3, 8, 0, 0, 0, 0, time.Local),
+		48 * time.Hour: time.Date(2020, 3, 9, 0, 0, 0, 0, time.Local),
+	} {
+		fs := &FileSet{rotateInterval: d}
+		if next := fs.nextRotation(tm); !next.Equal(exp) {
+			t.Errorf("next rotation after %s: %s", d, next)
+		}
+	}
+}
+
+func TestMaxTotalBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := Open(&Config{
+		LogDir:        dir,
+		LogName:       "total",
+		MaxFileSize:   100,
+		MaxNumFiles:   100,
+		MaxTotalBytes: 600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(fs, "line %02d ......................................\n", i)
+	}
+	fs.Close()
+	// checkOld checks that the log files of name preceding the current file and a new file do
+	// not exceed max bytes
+	checkOld := func(name string, max int64) {
+		logFiles := ListLogFiles(dir, name)
+		if len(logFiles) < 2 {
+			t.Fatalf("%d log files", len(logFiles))
+		}
+		var old int64
+		for _, fname := range logFiles[:len(logFiles)-1] {
+			fi, err := os.Stat(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old += fi.Size()
+		}
+		if old+100 > max {
+			t.Errorf("%d bytes in %d log files preceding the current file", old, len(logFiles)-1)
+		}
+	}
+	checkOld("total", 600)
+
+	// a MaxTotalBytes lowered by Reconfigure applies immediately
+	fs, err = Open(&Config{
+		LogDir:      dir,
+		LogName:     "lowered",
+		MaxFileSize: 100,
+		MaxNumFiles: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(fs, "line %02d ......................................\n", i)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Reconfigure(&Config{
+		MaxFileSize:   100,
+		MaxNumFiles:   100,
+		MaxTotalBytes: 500,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkOld("lowered", 500)
+}
+
+func TestTimeout(t *testing.T) {
+	// a FileSet without its goroutine is stalled
+	fs := newFileSet(&Config{
+		LogDir:       "logs",
+		LogName:      "timeout",
+		Timeout:      20 * time.Millisecond,
+		WriteTimeout: 20 * time.Millisecond,
+	})
+	if n, err := fs.Write([]byte("stalled\n")); n != 0 || err != ErrTimeout {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+	if err := fs.SetConfig(3, 1000); err != ErrTimeout {
+		t.Errorf("SetConfig = %v", err)
+	}
+	if err := fs.Close(); err != ErrTimeout {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(fs *FileSet, line string, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := fs.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cfg := &Config{
+		LogDir:      dir,
+		LogName:     "dedup",
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+		Dedup:       true,
+	}
+	fs, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three files with identical contents and one different one
+	write(fs, "startup...\n", 30)
+	write(fs, "different\n", 5)
+	fs.Close()
+	logFiles := ListLogFiles(dir, "dedup")
+	if len(logFiles) != 2 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+
+	// A crash-looping program writes the same log again
+	for i := 0; i < 2; i++ {
+		if fs, err = Open(cfg); err != nil {
+			t.Fatal(err)
+		}
+		write(fs, "different\n", 5)
+		fs.Close()
+	}
+	if fs, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	if logFiles = ListLogFiles(dir, "dedup"); len(logFiles) != 2 {
+		t.Fatalf("%d log files after restarts: %v", len(logFiles), logFiles)
+	}
+
+	// The preceding file is compressed after it was listed
+	older, newer := filepath.Join(dir, "gz_1.log"), filepath.Join(dir, "gz_2.log")
+	for _, fname := range []string{older, newer} {
+		if err := ioutil.WriteFile(fname, []byte("same\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := compressFile(older); err != nil {
+		t.Fatal(err)
+	}
+	remaining, removed, err := dedup([]string{older, newer}, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0] != older || removed != newer {
+		t.Errorf("remaining files %v, removed %s", remaining, removed)
+	}
+	if _, err := os.Stat(newer); !os.IsNotExist(err) {
+		t.Errorf("duplicate %s not removed: %v", newer, err)
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(dir, "close", 1000, 2)
+	if _, err := fs.WriteString("before close\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := fs.Close(); err != nil {
+			t.Errorf("Close %d: %s", i+2, err)
+		}
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("repeated Close took %s", d)
+	}
+	if n, err := fs.Write([]byte("after close\n")); n != 0 || err != ErrClosed {
+		t.Errorf("Write after Close = %d, %v", n, err)
+	}
+	if err := fs.Sync(); err != ErrClosed {
+		t.Errorf("Sync after Close = %v", err)
+	}
+	fs.SetConfig(3, 2000)
+}
+
+func TestWriteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(filepath.Join(dir, "logs"), "error", 10, 2)
+	// The next log file cannot be created after the log directory is removed
+	if err := os.RemoveAll(filepath.Join(dir, "logs")); err != nil {
+		t.Fatal(err)
+	}
+	buf := []byte("the first write rotates\n")
+	if n, err := fs.Write(buf); n != len(buf) || err == nil {
+		t.Errorf("rotating Write = %d, %v", n, err)
+	}
+	if n, err := fs.Write(buf); n != 0 || err == nil {
+		t.Errorf("Write without log file = %d, %v", n, err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Errorf("Close = %s", err)
+	}
+}
+
+func TestRetentionAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := []string{
+		now.Add(-72 * time.Hour).Format(time.RFC3339Nano),
+		now.Add(-25 * time.Hour).UTC().Format(time.RFC3339Nano),
+	}
+	recent := now.Add(-time.Hour).Format(time.RFC3339Nano)
+	for _, tm := range append(old, recent) {
+		fname := filepath.Join(dir, "age_"+tm+".log")
+		if err := ioutil.WriteFile(fname, []byte("old log\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := Open(&Config{
+		LogDir:       dir,
+		LogName:      "age",
+		MaxFileSize:  1000,
+		MaxNumFiles:  10,
+		RetentionAge: 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("new log\n"))
+	fs.Close()
+	logFiles := ListLogFiles(dir, "age")
+	if len(logFiles) != 2 || logFiles[0] != filepath.Join(dir, "age_"+recent+".log") {
+		t.Errorf("log files %v", logFiles)
+	}
+}
+
+// openFDs returns the number of open file descriptors of the process
+func openFDs(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count file descriptors:", err)
+	}
+	return len(fds)
+}
+
+func TestRotationFDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	before := openFDs(t)
+	fs, err := Open(&Config{
+		LogDir:      dir,
+		LogName:     "fds",
+		MaxFileSize: 10,
+		MaxNumFiles: 3,
+		Dedup:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := fs.Write([]byte("rotates every write\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := openFDs(t); n != before+1 {
+		t.Errorf("%d open file descriptors after 500 rotations, expected %d", n, before+1)
+	}
+	fs.Close()
+	if n := openFDs(t); n != before {
+		t.Errorf("%d open file descriptors after Close, expected %d", n, before)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(dir, "health", 1000, 2)
+	if err := fs.HealthCheck(); err != nil {
+		t.Fatal(err)
+	}
+	logFiles := ListLogFiles(dir, "health")
+	if err := os.Remove(logFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.HealthCheck(); err == nil || !strings.Contains(err.Error(), logFiles[0]) {
+		t.Errorf("HealthCheck after removing the log file = %v", err)
+	}
+	// A new file with the same name is not the open log file
+	if err := ioutil.WriteFile(logFiles[0], nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.HealthCheck(); err == nil || !strings.Contains(err.Error(), "replaced") {
+		t.Errorf("HealthCheck after replacing the log file = %v", err)
+	}
+	fs.Close()
+	if err := fs.HealthCheck(); err != ErrClosed {
+		t.Errorf("HealthCheck after Close = %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(dir, "stats", 100, 5)
+	// 2 rotations
+	for i := 0; i < 25; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := fs.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFiles := ListLogFiles(dir, "stats")
+	total, err := CurrentDiskUsage(dir, "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.CurrentFile != logFiles[len(logFiles)-1] || st.MaxFileSize != 100 ||
+		st.NumFiles != len(logFiles) || st.TotalBytes != total {
+		t.Errorf("stats %+v, log files %v of %d bytes", st, logFiles, total)
+	}
+	// the size does not include the header
+	if st.CurrentFileSize != 55 {
+		t.Errorf("current file size %d", st.CurrentFileSize)
+	}
+	fs.Close()
+	if _, err := fs.Stats(); err != ErrClosed {
+		t.Errorf("Stats after Close = %v", err)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logDir := filepath.Join(dir, "logs")
+	fs, err := Open(&Config{
+		LogDir:      logDir,
+		LogName:     "mode",
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+		FileMode:    0640,
+		DirMode:     0750,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a rotation
+	for i := 0; i < 15; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	// the umask may remove permissions but does not add any
+	if fi, err := os.Stat(logDir); err != nil || fi.Mode().Perm()&^0750 != 0 {
+		t.Errorf("log directory mode %v: %v", fi.Mode(), err)
+	}
+	logFiles := ListLogFiles(logDir, "mode")
+	if len(logFiles) != 2 || !strings.HasSuffix(logFiles[0], compressedSuffix) {
+		t.Fatalf("log files %v", logFiles)
+	}
+	for _, fname := range logFiles {
+		if fi, err := os.Stat(fname); err != nil || fi.Mode().Perm()&^0640 != 0 {
+			t.Errorf("%s mode %v: %v", fname, fi.Mode(), err)
+		}
+	}
+
+	// a FileMode set by Reconfigure applies to the files created after the change
+	fs, err = Open(&Config{
+		LogDir:      logDir,
+		LogName:     "remode",
+		MaxFileSize: 100,
+		MaxNumFiles: 3,
+		FileMode:    0644,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Reconfigure(&Config{MaxFileSize: 100, MaxNumFiles: 3, FileMode: 0600}); err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("0123456789\n"))
+	if err := fs.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("0123456789\n"))
+	fs.Close()
+	logFiles = ListLogFiles(logDir, "remode")
+	if len(logFiles) != 2 {
+		t.Fatalf("log files %v", logFiles)
+	}
+	for i, mode := range []os.FileMode{0644, 0600} {
+		if fi, err := os.Stat(logFiles[i]); err != nil || fi.Mode().Perm()&^mode != 0 {
+			t.Errorf("%s mode %v: %v", logFiles[i], fi.Mode(), err)
+		}
+	}
+}
+
+func TestFileTimeFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const layout = "20060102-150405.000000"
+	fs, err := Open(&Config{
+		LogDir:         dir,
+		LogName:        "layout",
+		MaxFileSize:    100,
+		MaxNumFiles:    5,
+		FileTimeFormat: layout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Millisecond)
+	// 2 rotations
+	for i := 0; i < 25; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	logFiles := ListLogFiles(dir, "layout")
+	if len(logFiles) != 3 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for _, fname := range logFiles {
+		if strings.Contains(filepath.Base(fname), ":") {
+			t.Errorf("invalid file name %s", fname)
+		}
+		if tm, ok := fs.fileTime(fname); !ok || tm.Before(start) || tm.After(time.Now()) {
+			t.Errorf("%s: time %s, %t", fname, tm, ok)
+		}
+	}
+
+	// several rotations within a second
+	dir1 := filepath.Join(dir, "seconds")
+	fs, err = Open(&Config{
+		LogDir:         dir1,
+		LogName:        "sec",
+		MaxFileSize:    200,
+		MaxNumFiles:    20,
+		FileTimeFormat: "20060102-150405",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat(".", 49) + "\n"
+	for i := 0; i < 40; i++ {
+		if _, err := fs.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+	logFiles = ListLogFiles(dir1, "sec")
+	if len(logFiles) != 10 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for _, fname := range logFiles {
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(buf), line); n != 4 {
+			t.Errorf("%s: %d lines", fname, n)
+		}
+		if _, ok := fs.fileTime(fname); !ok {
+			t.Errorf("%s: no time", fname)
+		}
+	}
+
+	for _, layout := range []string{"2006/01/02", "[15]", "log"} {
+		if _, err := Open(&Config{LogDir: dir, LogName: "bad", FileTimeFormat: layout}); err == nil {
+			t.Errorf("expected error for layout %s", layout)
+		}
+		if CheckFileTimeFormat(layout) == nil {
+			t.Errorf("CheckFileTimeFormat(%q) = nil", layout)
+		}
+	}
+	for _, layout := range []string{"", layout, time.RFC3339Nano} {
+		if err := CheckFileTimeFormat(layout); err != nil {
+			t.Errorf("CheckFileTimeFormat(%q) = %s", layout, err)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{
+		LogDir:      dir,
+		LogName:     "append",
+		MaxFileSize: 1000,
+		MaxNumFiles: 3,
+		Append:      true,
+	}
+	// 5 restarts
+	for i := 0; i < 5; i++ {
+		fs, err := Open(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fmt.Fprintf(fs, "start %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+		fs.Close()
+	}
+	logFiles := ListLogFiles(dir, "append")
+	if len(logFiles) != 1 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	buf, err := ioutil.ReadFile(logFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "start 0\n") || !strings.Contains(string(buf), "start 4\n") {
+		t.Errorf("log file:\n%s", buf)
+	}
+
+	// a full file is not continued
+	fs, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the write fills the file and rotates to an empty file, which is removed by Close
+	fs.Write([]byte(strings.Repeat(".", 1000) + "\n"))
+	fs.Close()
+	logFiles = ListLogFiles(dir, "append")
+	fs, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Write([]byte("after\n"))
+	fs.Close()
+	if n := len(ListLogFiles(dir, "append")); n != len(logFiles)+1 {
+		t.Errorf("%d log files after opening %v", n, logFiles)
+	}
+}
+
+func TestTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := Open(&Config{
+		LogDir:      dir,
+		LogName:     "tail",
+		MaxFileSize: 100,
+		MaxNumFiles: 10,
+		BufferSize:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fmt.Fprintf(fs, "before\n")
+	lines, cancel, err := Tail(dir, "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	// 3 rotations
+	go func() {
+		for i := 0; i < 30; i++ {
+			fmt.Fprintf(fs, "line %02d\n", i)
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 30; {
+		select {
+		case line := <-lines:
+			if line == "before" {
+				t.Fatal("line written before Tail")
+			}
+			if !strings.HasPrefix(line, "line ") {
+				// a file set header
+				continue
+			}
+			if exp := fmt.Sprintf("line %02d", i); line != exp {
+				t.Fatalf("%q, expected %q", line, exp)
+			}
+			i++
+		case <-timeout:
+			t.Fatalf("timeout after %d lines", i)
+		}
+	}
+	cancel()
+	if _, ok := <-lines; ok {
+		t.Error("channel open after cancel")
+	}
+	if _, _, err := Tail(filepath.Join(dir, "missing"), "tail"); err == nil {
+		t.Error("no error for missing log directory")
+	}
+}
+
+func TestOnRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := New(dir, "callback", 100, 2)
+	var rotated [][2]string
+	var deleted []string
+	fs.OnRotate(func(closedPath, newPath string) {
+		rotated = append(rotated, [2]string{closedPath, newPath})
+	})
+	fs.OnDelete(func(path string) {
+		deleted = append(deleted, path)
+	})
+	first := ListLogFiles(dir, "callback")[0]
+	// 3 rotations
+	for i := 0; i < 35; i++ {
+		if _, err := fs.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	logFiles := ListLogFiles(dir, "callback")
+	if len(rotated) != 3 || rotated[0][0] != first || rotated[2][1] != logFiles[1] {
+		t.Errorf("rotations %v, log files %v", rotated, logFiles)
+	}
+	for i := 1; i < len(rotated); i++ {
+		if rotated[i][0] != rotated[i-1][1] {
+			t.Errorf("rotation %d: %v", i, rotated[i])
+		}
+	}
+	if len(deleted) != 2 || deleted[0] != first || deleted[1] != rotated[0][1] {
+		t.Errorf("deleted %v, rotations %v", deleted, rotated)
+	}
+}
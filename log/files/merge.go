@@ -0,0 +1,207 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package files
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+MergeReaders returns a reader of the lines of all the log files of logNames in logDir,
+interleaved in timestamp order.
+
+The timestamp of a line is its leading RFC3339Nano time. A line without a parseable timestamp,
+e.g.: a line of a stack trace, is attached to the preceding timestamped line of the same log.
+Lines at the start of a log that precede its first timestamped line are read first.
+
+The caller must close the returned reader.
+*/
+func MergeReaders(logDir string, logNames []string) (io.ReadCloser, error) {
+	return MergeReadersLayout(logDir, logNames, time.RFC3339Nano)
+}
+
+/*
+MergeReadersLayout is MergeReaders for log files with timestamps in the Go time layout layout,
+e.g. the TimeFormat of the logger. The timestamp of a line that is a JSON object, e.g.:
+{"time":"...","level":"INFO",...}, is its "time" member.
+*/
+func MergeReadersLayout(logDir string, logNames []string, layout string) (io.ReadCloser, error) {
+	srcs := make([]*entrySource, 0, len(logNames))
+	for _, logName := range logNames {
+		src := &entrySource{files: ListLogFiles(logDir, logName), layout: layout}
+		if err := src.next(); err != nil {
+			src.close()
+			for _, src1 := range srcs {
+				src1.close()
+			}
+			return nil, err
+		}
+		srcs = append(srcs, src)
+	}
+	pr, pw := io.Pipe()
+	go merge(pw, srcs)
+	return pr, nil
+}
+
+// entry is a timestamped line followed by its untimestamped lines
+type entry struct {
+	time time.Time
+	text string
+}
+
+// entrySource reads the entries of the log files of one log name, oldest file first
+type entrySource struct {
+	files     []string
+	layout    string
+	file      io.ReadCloser
+	rdr       *bufio.Reader
+	lookAhead string
+	entry     *entry
+}
+
+func merge(pw *io.PipeWriter, srcs []*entrySource) {
+	defer func() {
+		for _, src := range srcs {
+			src.close()
+		}
+	}()
+	for {
+		var first *entrySource
+		for _, src := range srcs {
+			if src.entry != nil && (first == nil || src.entry.time.Before(first.entry.time)) {
+				first = src
+			}
+		}
+		if first == nil {
+			pw.Close()
+			return
+		}
+		if _, err := io.WriteString(pw, first.entry.text); err != nil {
+			return
+		}
+		if err := first.next(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func (src *entrySource) close() {
+	if src.file != nil {
+		src.file.Close()
+		src.file = nil
+	}
+}
+
+// next reads the next entry of src into src.entry. src.entry is nil when src is exhausted.
+func (src *entrySource) next() error {
+	var tm time.Time
+	if src.entry != nil {
+		// lines preceding a timestamp in a later file belong to the last entry
+		tm = src.entry.time
+	}
+	src.entry = nil
+	var text strings.Builder
+	line := src.lookAhead
+	src.lookAhead = ""
+	if line != "" {
+		tm, _ = lineTime(line, src.layout)
+		text.WriteString(line)
+	}
+	for {
+		line, err := src.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		if t, ok := lineTime(line, src.layout); ok {
+			if text.Len() > 0 {
+				src.lookAhead = line
+				break
+			}
+			tm = t
+		}
+		text.WriteString(line)
+	}
+	if text.Len() > 0 {
+		src.entry = &entry{time: tm, text: text.String()}
+	}
+	return nil
+}
+
+// readLine returns the next line of src terminated by "\n", or "" when all files have been read.
+func (src *entrySource) readLine() (string, error) {
+	for {
+		if src.file == nil {
+			if len(src.files) == 0 {
+				return "", nil
+			}
+			f, err := openLogFile(src.files[0])
+			if err != nil {
+				return "", err
+			}
+			src.files = src.files[1:]
+			src.file, src.rdr = f, bufio.NewReader(f)
+		}
+		line, err := src.rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if err == io.EOF {
+			src.close()
+			if line == "" {
+				continue
+			}
+			line += "\n"
+		}
+		return line, nil
+	}
+}
+
+// lineTime returns the timestamp in the time layout layout of line and true, or false if line
+// has no timestamp. The timestamp of a JSON line is its "time" member and that of a text line
+// its leading time.
+func lineTime(line, layout string) (time.Time, bool) {
+	if strings.HasPrefix(line, "{") {
+		var v struct {
+			Time string `json:"time"`
+		}
+		if json.Unmarshal([]byte(line), &v) != nil || v.Time == "" {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(layout, v.Time)
+		return t, err == nil
+	}
+	// The layout may contain spaces, so the time ends at one of the spaces following it. A
+	// formatted time is not much longer than its layout, e.g. "January" for "Jan".
+	maxLen := len(layout) + 16
+	for end := 0; end < len(line) && end <= maxLen; end++ {
+		if i := strings.IndexAny(line[end:], " \r\n"); i < 0 {
+			end = len(line)
+		} else {
+			end += i
+		}
+		if t, err := time.Parse(layout, line[:end]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
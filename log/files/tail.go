@@ -0,0 +1,208 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package files
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tailPollInterval is the time Tail waits for new lines at the end of the current log file
+const tailPollInterval = 100 * time.Millisecond
+
+/*
+Tail returns a channel of the lines, without line endings, appended to the log files of logName
+in logDir after the call, e.g. to stream the live log:
+
+	lines, cancel, err := files.Tail(logDir, "myapp")
+	if err != nil {
+		...
+	}
+	defer cancel()
+	for line := range lines {
+		...
+	}
+
+Tail starts at the end of the newest log file and continues with the next log file, from its
+start, when the log files are rotated. The log files are listed by ListLogFiles. The cancel
+function stops the tail and closes the channel. It may be called more than once.
+
+Tail returns an error if logDir or the newest log file cannot be read.
+*/
+func Tail(logDir, logName string) (<-chan string, func(), error) {
+	if _, err := os.Stat(logDir); err != nil {
+		return nil, nil, fmt.Errorf("Error reading log directory %s: %s", logDir, err)
+	}
+	t := &tailer{
+		logDir:   logDir,
+		logName:  logName,
+		lines:    make(chan string),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	if logFiles := ListLogFiles(logDir, logName); len(logFiles) > 0 {
+		fname := logFiles[len(logFiles)-1]
+		// a compressed newest file is not written, so the next file is tailed
+		t.name = strings.TrimSuffix(fname, compressedSuffix)
+		if fname == t.name {
+			f, err := os.Open(fname)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, err := f.Seek(0, io.SeekEnd); err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			t.file, t.rdr = f, bufio.NewReader(f)
+		}
+	}
+	go t.run()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(t.done) })
+		<-t.finished
+	}
+	return t.lines, cancel, nil
+}
+
+// tailer reads the lines appended to the log files of a log name, see Tail
+type tailer struct {
+	logDir  string
+	logName string
+	// name is the name, without the compressed suffix, of the log file being read. It is empty
+	// if no log file has been read.
+	name     string
+	file     io.ReadCloser
+	rdr      *bufio.Reader
+	partial  string
+	lines    chan string
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func (t *tailer) run() {
+	defer func() {
+		t.closeFile()
+		close(t.lines)
+		close(t.finished)
+	}()
+	for {
+		if t.file == nil && !t.next() {
+			if !t.wait() {
+				return
+			}
+			continue
+		}
+		if !t.readLines() {
+			return
+		}
+		if !t.rotated() {
+			if !t.wait() {
+				return
+			}
+			continue
+		}
+		// The rotation may have happened after the end of the file was read. The file is
+		// complete when the next file exists, so reading it again reads all its lines.
+		if !t.readLines() {
+			return
+		}
+		if t.partial != "" && !t.send(t.partial) {
+			return
+		}
+		t.partial = ""
+		t.closeFile()
+	}
+}
+
+// readLines sends the complete lines up to the end of the current file. It returns false if the
+// tail has been cancelled.
+func (t *tailer) readLines() bool {
+	for {
+		line, err := t.rdr.ReadString('\n')
+		t.partial += line
+		if err != nil {
+			// a read error ends the file like a rotation
+			return true
+		}
+		line, t.partial = t.partial, ""
+		if !t.send(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")) {
+			return false
+		}
+	}
+}
+
+// next opens the log file following the current file and returns true, or returns false if
+// there is none
+func (t *tailer) next() bool {
+	for _, fname := range ListLogFiles(t.logDir, t.logName) {
+		name := strings.TrimSuffix(fname, compressedSuffix)
+		if name <= t.name {
+			continue
+		}
+		// the file may be compressed or deleted since it was listed, in which case it is
+		// opened or skipped after the next poll
+		f, err := openLogFile(fname)
+		if err != nil {
+			return false
+		}
+		t.name, t.file, t.rdr = name, f, bufio.NewReader(f)
+		return true
+	}
+	return false
+}
+
+// rotated returns true if a log file newer than the current file exists
+func (t *tailer) rotated() bool {
+	logFiles := ListLogFiles(t.logDir, t.logName)
+	return len(logFiles) > 0 &&
+		strings.TrimSuffix(logFiles[len(logFiles)-1], compressedSuffix) > t.name
+}
+
+// send sends line to the channel of t and returns true, or returns false if the tail has been
+// cancelled
+func (t *tailer) send(line string) bool {
+	select {
+	case t.lines <- line:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// wait waits for the poll interval and returns true, or returns false if the tail has been
+// cancelled
+func (t *tailer) wait() bool {
+	timer := time.NewTimer(tailPollInterval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *tailer) closeFile() {
+	if t.file != nil {
+		t.file.Close()
+		t.file, t.rdr = nil, nil
+	}
+}
@@ -0,0 +1,193 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// field is a key/value pair of a log message
+type field struct {
+	key   string
+	value interface{}
+}
+
+// entry returns the log entry of a message with the fields msgFields in the format of l.
+// exitCode is only used if priority is EXIT. pc is the program counter of the log call, or 0
+// if it is unknown.
+func (l *logger) entry(priority Priority, exitCode int, pc uintptr, file string, line int,
+	msg, stackTrace string, msgFields []*field) string {
+
+	tm := l.now()
+	seq, hasSeq := l.nextSeq()
+	fn := ""
+	if l.cfg.FuncName {
+		fn = funcName(pc)
+	}
+	if l.cfg.Format == FormatJSON {
+		fields := []*field{
+			{"time", tm},
+			{"level", priority.String()},
+			{"file", l.caller(file)},
+		}
+		if fn != "" {
+			fields = append(fields, &field{"func", fn})
+		}
+		fields = append(fields,
+			&field{"line", line},
+			&field{"msg", msg})
+		if priority == EXIT {
+			fields = append(fields, &field{"exitCode", exitCode})
+		}
+		if hasSeq {
+			fields = append(fields, &field{"seq", seq})
+		}
+		fields = append(fields, l.fields...)
+		fields = append(fields, msgFields...)
+		if stackTrace != "" {
+			fields = append(fields, &field{"stacktrace", strings.TrimRight(stackTrace, "\n")})
+		}
+		return jsonLine(fields)
+	}
+	level := priority.String()
+	if priority == EXIT {
+		level = fmt.Sprintf("EXIT %d", exitCode)
+	}
+	seqText := ""
+	if hasSeq {
+		seqText = fmt.Sprintf(" seq=%d", seq)
+	}
+	caller := l.caller(file)
+	if fn != "" {
+		caller += "/" + fn
+	}
+	return fmt.Sprintf("%s%s%s [%s] -%s, line %d- %s%s\n%s",
+		tm,
+		seqText,
+		l.fieldsText,
+		level,
+		caller, line,
+		msg,
+		fieldsText(msgFields),
+		strings.TrimRight(stackTrace, "\n"))
+}
+
+// jsonLine returns the JSON object of fields, in order, terminated by "\n"
+func jsonLine(fields []*field) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		enc.Encode(f.key)
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(f.value); err != nil {
+			enc.Encode(fmt.Sprint(f.value))
+		}
+		// Encode terminates its output with "\n"
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// logJSONConfig writes the configuration of l as a JSON object to all its log files, or to its
+// output if it is set
+func (l *logger) logJSONConfig() {
+	fields := []*field{
+		{"time", l.now()},
+		{"msg", "Log configuration"},
+		{"config", l.cfg.toJSONConfig()},
+	}
+	if l.version != "" {
+		fields = append(fields, &field{"version", l.version})
+	}
+	if l.cfg.Sequence {
+		fields = append(fields, &field{"nextSeq", l.seq})
+	}
+	l.writeAll(jsonLine(fields))
+}
+
+// now returns the current time in the time format of l
+func (l *logger) now() string {
+	return l.formatTime(time.Now())
+}
+
+// formatTime returns tm in the time format of l
+func (l *logger) formatTime(tm time.Time) string {
+	if l.cfg.UTC {
+		tm = tm.UTC()
+	}
+	tf := l.cfg.TimeFormat
+	if tf == "" {
+		tf = DefaultTimeFormat
+	}
+	return tm.Format(tf)
+}
+
+// nextSeq returns the sequence number of the next message and true, and increments the
+// sequence number, or returns false if sequence numbers are not configured.
+func (l *logger) nextSeq() (uint64, bool) {
+	if !l.cfg.Sequence {
+		return 0, false
+	}
+	l.seq++
+	return l.seq - 1, true
+}
+
+// setFields sets the fields of l to the fields of its configuration: hostname and pid if
+// cfg.Identity is set, followed by cfg.Fields sorted by key. The text format of the fields
+// is, e.g.: " hostname=h1 pid=42 app=x".
+func (l *logger) setFields() {
+	l.fields = nil
+	if l.cfg.Identity {
+		l.fields = append(l.fields, &field{"hostname", hostname}, &field{"pid", pid})
+	}
+	keys := make([]string, 0, len(l.cfg.Fields))
+	for k := range l.cfg.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l.fields = append(l.fields, &field{k, l.cfg.Fields[k]})
+	}
+	l.fieldsText = fieldsText(l.fields)
+}
+
+// fieldsText returns the text format of fields, e.g.: " request_id=42 user=bob"
+func fieldsText(fields []*field) string {
+	var sb strings.Builder
+	for _, f := range fields {
+		writeField(&sb, f.key, fmt.Sprint(f.value))
+	}
+	return sb.String()
+}
+
+// writeField writes " key=value" to sb, quoting value if it contains spaces, quotes or '='
+func writeField(sb *strings.Builder, key, value string) {
+	if value == "" || strings.ContainsAny(value, " \t\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(sb, " %s=%s", key, value)
+}
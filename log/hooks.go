@@ -0,0 +1,51 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+// Hook is called for every message written by the logger with the priority, source file path,
+// line number and formatted text of the message. See AddHook.
+type Hook func(p Priority, file string, line int, msg string)
+
+// HookID identifies a hook added by AddHook
+type HookID uint64
+
+type hook struct {
+	id HookID
+	f  Hook
+}
+
+// addHook adds f to the hooks of l and returns its id
+func (l *logger) addHook(f Hook) HookID {
+	l.nextHookID++
+	l.hooks = append(l.hooks, &hook{id: l.nextHookID, f: f})
+	return l.nextHookID
+}
+
+// removeHook removes the hook with id from the hooks of l
+func (l *logger) removeHook(id HookID) {
+	for i, h := range l.hooks {
+		if h.id == id {
+			l.hooks = append(l.hooks[:i], l.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// callHooks calls the hooks of l in the order in which they were added
+func (l *logger) callHooks(priority Priority, file string, line int, msg string) {
+	for _, h := range l.hooks {
+		h.f(priority, file, line, msg)
+	}
+}
@@ -17,7 +17,8 @@ Package log supports logging to a managed set of log files.
 
 The logger is configured by a JSON file called <component>.log.config. <component> is the name of
 the go binary executable (os.Executable()).
-The logger looks for the log config file in $PWD.
+The logger looks for the log config file in $PWD, unless the environment variable
+GOUTIL_LOG_CONFIG contains the path of the log config file.
 
 The following is a JSON configuration structure containing the default logger paramerters:
 
@@ -26,31 +27,143 @@ The following is a JSON configuration structure containing the default logger pa
 		"NumFiles": 3,
 		"FileNumBytes": 1000000,
 		"Priority": "INFO",
-		"SuppressedFiles": ""
+		"SuppressedFiles": "",
+		"LineEnding": "\n"
 	}
 
 If the working directory does not contain a log.config file the logger uses these parameters. All
 fields of log.config are optional. The logger will used default values for missing parameters.
 
-The logger reads log.config periodically. The logger uses changed parameters. The log.config can
-be changed while the program is running and further logging reflects the changed log.config.
+Format is "text" (the default) or "json". In JSON format every line of the log, including the
+headers of the log files, is a JSON object, e.g.:
+
+	{"time":"2020-01-02T15:04:05Z","level":"INFO","file":"main.go","line":14,"msg":"started"}
+
+The stack trace of a PANIC message is its "stacktrace" field, and the Identity and Fields
+fields are top-level keys of every message.
+
+LineEnding is the terminator of every line written to the log. It may be "\n" (the default) or
+"\r\n" for consumers that expect CRLF line endings.
+
+"Console": true mirrors every message to the terminal: EXIT, PANIC and WARNING messages to
+os.Stderr and INFO, DEBUG and TRACE messages to os.Stdout. The log files are unchanged.
+"Color": true colors the console messages by priority, red for EXIT and PANIC, yellow for
+WARNING and dim for DEBUG and TRACE, if the console is a terminal. "ForceColor": true colors them
+even if it is not, e.g. for a CI log that renders color. Log files are never colored.
+
+CallerStyle determines how the source file of a message is shown: "base" (the default) shows
+the file name, e.g. "handler.go"; "package/file" adds the directory, e.g. "log/handler.go", to
+distinguish files with the same name; "full" shows the full path.
+"FuncName": true adds the name of the calling function, e.g. "-main.go/doWork, line 14-", and a
+"func" field to JSON messages.
+
+Timestamps are local RFC3339Nano times by default. "TimeFormat" sets the Go time layout of the
+timestamps, e.g. "2006-01-02 15:04:05.000", and "UTC": true shows them in UTC, e.g. to compare
+the logs of servers in different time zones. An invalid layout is reported once on os.Stderr
+and the default is used.
+
+The header at the start of every log file shows the hostname and pid of the process, to tell
+apart the files of several instances of a program sharing a RootDir. "MinimalHeader": true
+omits them.
+
+"RotateInterval": "24h" starts a new log file every day at midnight, in addition to the size
+limit, e.g. for audit logs.
+
+"MaxTotalBytes": 500000000 deletes the oldest log files so that the log files never use more than
+500MB, in addition to the NumFiles limit.
+
+"Compress": true gzips every log file when it is rotated out, to <name>.log.gz.
+
+"FileMode": "0640" and "DirMode": "0750" restrict the permissions of the log files and of the
+log directories the logger creates, e.g. for logs containing sensitive data. By default they
+are 0666 and 0777, modified by the umask of the process.
+
+"FileTimeFormat": "20060102-150405" sets the Go time layout of the time in the log file names,
+e.g. for names without colons. The default is RFC3339Nano.
+
+"Append": true continues the newest log file when the program starts, if it is not full,
+instead of starting a new file, so that a program that restarts frequently keeps its history.
+
+The optional Routes field directs the messages of selected priorities to their own sets of log
+files, e.g. to keep PANIC and WARNING messages in a separate problems log:
+
+	"Routes": [{ "From": "PANIC", "To": "WARNING", "RootDir": "/usr/local/var/log/problems" }]
+
+See Route for details.
+
+"Identity": true adds the hostname and pid of the process to every message, and "Fields" adds
+a fixed set of key/values, e.g.: "Fields": {"service": "billing"}. The fields follow the time
+of the message: 2020-01-02T15:04:05Z hostname=h1 pid=42 service=billing [INFO] ...
+
+"Sequence": true numbers the messages written by the logger consecutively, starting at
+"SequenceBase" (default 0), to let consumers of the log detect missing or reordered messages:
+2020-01-02T15:04:05Z seq=7 [INFO] ...
+
+Log messages are buffered for the logger. By default a log call blocks while the buffer is full.
+"DropWhenFull": true discards the message instead, for latency-sensitive programs. log.Stats()
+returns the number of discarded messages.
+
+The logger watches the directory of log.config and re-reads log.config when it has been created,
+written or replaced, e.g. by an editor renaming a new version over it. If the directory cannot be
+watched the logger checks log.config every RefreshInterval instead, e.g.: "RefreshInterval": "1m"
+or 60 (seconds), by default every 10 seconds. The logger uses changed parameters.
+The log.config can be changed while the program is running and further logging reflects the
+changed log.config.
+log.DisableConfigReload() stops the logger from re-reading log.config.
 
 The logger initialises and closes automatically but log.Close() should be called to ensure that
-the last logged items are properly flushed before the program terminates.
+the last logged items are properly flushed before the program terminates. log.Init(cfg) may be
+called to configure the logger from a Config instead of log.config. Init returns an error if
+the log directory or the first log file cannot be created.
 
 log.Panic(...) and log.Panicf(...) log a stack trace in addition to the log message, close the
-open log file and call os.Exit(1).
+open log file and call os.Exit(1). log.SetPanicFormatter(log.JSONPanicFormatter) logs panics as
+JSON crash reports, containing the stack trace split into frames, instead.
 
 The logger will automatically tag every log message with time, the source file and
 line number of the call to log.
 
-If the logger priority is DEBUG the logger can be configured to suppress the debug messages from one
-or more files by providing a comma separated string to the suppressFilesDebug parameter
-of log.Init(...). The components of the string correspond to file names without extension. E.g.:
-"pkga,pkgb" will suppress debug messages from pkga.go and pkgb.go.
+log.SetOutput(w) writes the log to the io.Writer w instead of the log files.
+log.CaptureForTest(t) directs the log of a test to a MemorySink, which records the lines of the
+log for the test to check.
+log.Disable() discards all messages without creating any log files, e.g. in a library.
+
+log.Enabled(priority) reports whether messages of priority are logged, and log.DebugFunc(f)
+calls f to construct a DEBUG message only if DEBUG messages are logged.
+
+"Dedup": true collapses consecutive identical messages, like syslog, into the first message
+followed by "previous message repeated N times".
+
+Functions that wrap the logger can use log.InfoDepth(1, msg), and WarningDepth, DebugDepth and
+TraceDepth, to attribute the message to the caller of the wrapper.
+
+log.Writer(priority) returns an io.Writer that logs each line written to it, e.g. as the output
+of the standard library logger.
+
+log.AddHook(f) calls f for every message written, e.g. to count warnings for a metrics system.
 
-Package log supports four Priority levels in decreasing order of priority:
-Panic, Warning, Info, Debug. The logger instance has two methods to log a message of each Priority:
+log.SetRateLimit(n) limits the messages logged from each source line to n per second, and logs
+the number of suppressed messages instead.
+
+log.WithFields(fields) returns a Logger that adds the key/values of fields to each of its
+messages, e.g.: log.WithFields(map[string]interface{}{"request_id": 42}).Info("login") logs
+"... login request_id=42". In JSON format the fields are members of the message object.
+log.WithContext(ctx, fields) returns a context carrying a Logger with fields, which is returned
+by log.FromContext(ctx) in request-scoped code.
+
+If the logger priority is DEBUG or TRACE the logger can be configured to suppress the debug and
+trace messages from one or more files by providing a comma separated string to the
+suppressFilesDebug parameter of log.Init(...). The components of the string correspond to file
+names without extension. E.g.: "pkga,pkgb" will suppress debug messages from pkga.go and pkgb.go.
+A component may be qualified by its package path, e.g. "github.com/me/app/auth/util", to
+distinguish files with the same name in different packages.
+log.EnableDebugFor(files) or "DebugFiles" in log.config does the opposite: only the debug and
+trace messages of the listed files are logged. Suppression takes precedence over DebugFiles.
+log.SuppressRegex(patterns...) suppresses them from the files whose names match a regular
+expression.
+
+Package log supports five Priority levels in decreasing order of priority:
+Panic, Warning, Info, Debug, Trace. The logger instance has two methods to log a message of each Priority:
 <Priority> and <Priority>f, e.g.: Info and Infof. <Priority> takes a string parameter, while
 <Priority>f takes a format string followed by a list of parameters. The format of the <Priorty>f
 format string parameter is the same as for fmt.Printf.
@@ -145,11 +258,17 @@ The resulting log is:
 package log
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/goccmack/goutil/log/files"
 )
 
 // Priority of a logging message
@@ -171,6 +290,9 @@ const (
 
 	// DEBUG is used for exeution tracing
 	DEBUG
+
+	// TRACE is for very verbose call-by-call tracing, below DEBUG
+	TRACE
 )
 
 func (p Priority) String() string {
@@ -185,6 +307,8 @@ func (p Priority) String() string {
 		return "INFO"
 	case DEBUG:
 		return "DEBUG"
+	case TRACE:
+		return "TRACE"
 	}
 	panic(fmt.Sprintf("Invalid priority %d", p))
 }
@@ -202,76 +326,443 @@ func ToPriority(str string) (Priority, error) {
 		return INFO, nil
 	case "DEBUG":
 		return DEBUG, nil
+	case "TRACE":
+		return TRACE, nil
 	}
 	return DEBUG, errors.New("Invalid priority string " + str)
 }
 
+// MarshalJSON returns the name of p as a JSON string, e.g.: "INFO".
+func (p Priority) MarshalJSON() ([]byte, error) {
+	if p < EXIT || p > TRACE {
+		return nil, fmt.Errorf("Invalid priority %d", p)
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON sets p from either a priority name, e.g.: "INFO" or "info", or a priority number.
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		p1, err := ToPriority(str)
+		if err != nil {
+			return err
+		}
+		*p = p1
+		return nil
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("Invalid priority %s", data)
+	}
+	if Priority(n) < EXIT || Priority(n) > TRACE {
+		return fmt.Errorf("Invalid priority %d", n)
+	}
+	*p = Priority(n)
+	return nil
+}
+
+// ErrClosed is returned by Init, Rotate, SetBufferSize and CloseTimeout after the logger has
+// been closed. The other functions of the logger return immediately after Close without effect.
+var ErrClosed = errors.New("logger closed")
+
+// DefaultCloseTimeout is the time for which Close waits for the logger to close
+const DefaultCloseTimeout = 5 * time.Second
+
 // Close is only necessary before os.Exit is called. Otherwise the logger will automatically
 // close open files when the programe terminates. Calling log.Close() before the client program
-// terminates will cause no harm.
+// terminates will cause no harm. Close writes all pending messages and returns when the log files
+// have been closed, or after DefaultCloseTimeout. See CloseTimeout.
 func Close() {
-	closeChan <- true
+	CloseTimeout(DefaultCloseTimeout)
+}
+
+// CloseTimeout writes all pending log messages, syncs and closes the log files and returns
+// within timeout. CloseTimeout returns an error if closing failed or did not complete within
+// timeout, or ErrClosed if the logger has already been closed. Messages logged after
+// CloseTimeout are discarded.
+func CloseTimeout(timeout time.Duration) error {
+	reply := make(chan error, 1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case closeChan <- reply:
+	case <-closedChan:
+		return ErrClosed
+	case <-timer.C:
+		return errors.New("Timeout waiting for logger to close")
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-timer.C:
+		return errors.New("Timeout waiting for logger to close")
+	}
+}
 
-	// Give logger time to close files
-	time.Sleep(time.Second)
+// Flush returns after all messages logged before the call have been written to the log files.
+// Unlike Close the logger remains usable after Flush, e.g. for a checkpoint before a risky
+// operation.
+func Flush() {
+	reply := make(chan bool)
+	select {
+	case flushChan <- reply:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// FlushAndClose is CloseTimeout. It does not panic and may be called from a signal handler
+// before os.Exit.
+func FlushAndClose(timeout time.Duration) error {
+	return CloseTimeout(timeout)
+}
+
+// DisableConfigReload stops the periodic re-reading of the log config file. The configuration
+// remains unchanged until it is changed explicitly, e.g. by SetConfig or Suppress.
+func DisableConfigReload() {
+	reply := make(chan bool)
+	select {
+	case disableChan <- reply:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+/*
+Disable discards all messages, e.g. in a library whose host application does not use this
+logger or in a benchmark. The messages logged before Disable are written and the log files are
+closed. If the logger has not been initialised Disable configures it with DefaultConfig without
+reading the log config file or creating the log directory and files. The logging functions
+return immediately and Enabled returns false. Exit and Panic still exit the program. GetConfig
+returns the configuration of the logger. Init enables the logger again.
+*/
+func Disable() {
+	reply := make(chan bool)
+	select {
+	case disableLogChan <- reply:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// Entry is a message logged by one of the logging functions, e.g. Info or Debugf
+type Entry struct {
+	Priority Priority
+	// File is the full path of the source file of the call to the logging function
+	File string
+	Line int
+	// Message is the formatted message
+	Message string
+}
+
+/*
+AddHook adds a hook, which is called for every message written by the logger, e.g. to count
+WARNING messages or to report PANIC messages to an alerting service. Hooks are called by the
+logger goroutine, one after the other, after discarded and suppressed messages have been filtered
+and before the message is written, including the messages of Panic and Exit before the program
+exits. A hook must not block and must not log. AddHook returns the id of the hook for RemoveHook.
+*/
+func AddHook(f Hook) HookID {
+	reply := make(chan HookID)
+	msg := &hookMsg{
+		f:       f,
+		replyTo: reply,
+	}
+	select {
+	case hookChan <- msg:
+		return <-reply
+	case <-closedChan:
+		return 0
+	}
+}
+
+// RemoveHook removes the hook with id returned by AddHook
+func RemoveHook(id HookID) {
+	reply := make(chan HookID)
+	msg := &hookMsg{
+		id:      id,
+		replyTo: reply,
+	}
+	select {
+	case hookChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+/*
+Rotate closes the current log files, after writing the messages logged before the call, and
+starts new ones, e.g. after a deployment or on SIGHUP:
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			log.Rotate()
+		}
+	}()
+
+An empty current log file is removed. Rotate returns the first error encountered, and has no
+effect if the log is written to an io.Writer set by SetOutput.
+*/
+func Rotate() error {
+	reply := make(chan error)
+	select {
+	case rotateChan <- reply:
+		return <-reply
+	case <-closedChan:
+		return ErrClosed
+	}
+}
+
+// RotateOn makes the logger start a new log file before writing every message for which
+// predicate returns true, e.g. to write every session to its own file. predicate is called
+// by the logger goroutine for every message that is not discarded, and must not log.
+// A nil predicate stops content driven rotation.
+func RotateOn(predicate func(Entry) bool) {
+	reply := make(chan bool)
+	msg := &rotateOnMsg{
+		predicate: predicate,
+		replyTo:   reply,
+	}
+	select {
+	case rotateOnChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// SetVersion sets the application version, e.g. a release and git commit, which is written to
+// the header of every log file, and to the current log file if the logger has been initialised.
+// SetVersion may be called before Init. To add the version to every message as well add it to
+// Config.Fields.
+func SetVersion(version string) {
+	reply := make(chan bool)
+	msg := &versionMsg{
+		version: version,
+		replyTo: reply,
+	}
+	select {
+	case versionChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+/*
+SetBufferSize sets the number of log messages buffered for the logger, which is DefaultBufferSize
+by default. SetBufferSize must be called before any other function of the logger, e.g. at the
+start of main. A message logged concurrently with SetBufferSize may be written to the replaced
+buffer and lost. It returns an error if size is not positive or if the logger has already been
+used.
+*/
+func SetBufferSize(size int) error {
+	reply := make(chan error)
+	msg := &bufferSizeMsg{
+		size:    size,
+		replyTo: reply,
+	}
+	select {
+	case bufferSizeChan <- msg:
+		return <-reply
+	case <-closedChan:
+		return ErrClosed
+	}
+}
+
+/*
+SetOutput directs all log messages to w instead of the log files, e.g. to capture the log in a
+unit test. The routes and the file rotation parameters of the configuration are ignored while an
+output is set, and no log files are created if the logger has not yet been initialised.
+SetOutput(nil) restores logging to the log files.
+
+Close writes all pending messages to w before it returns, but does not close w.
+*/
+func SetOutput(w io.Writer) {
+	reply := make(chan bool)
+	msg := &outputMsg{
+		w:       w,
+		replyTo: reply,
+	}
+	select {
+	case outputChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+/*
+SetRateLimit limits the messages logged from each source line, e.g. an error path in a hot loop,
+to maxPerSecond. Messages exceeding the limit are suppressed and counted, and the number of
+suppressed messages of each source line is logged every second, e.g.:
+
+	2020-01-02T15:04:05Z [WARNING] -main.go, line 14- 4000 messages suppressed from main.go:14
+
+SetRateLimit(0) disables rate limiting, which is the default. EXIT and PANIC messages are never
+rate limited.
+*/
+func SetRateLimit(maxPerSecond int) {
+	reply := make(chan bool)
+	msg := &rateLimitMsg{
+		maxPerSecond: maxPerSecond,
+		replyTo:      reply,
+	}
+	select {
+	case rateLimitChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// SetPanicFormatter sets the formatter of the messages logged by Panic and Panicf, e.g.:
+// log.SetPanicFormatter(log.JSONPanicFormatter). A nil f restores the default format.
+func SetPanicFormatter(f PanicFormatter) {
+	reply := make(chan bool)
+	msg := &panicFormatterMsg{
+		f:       f,
+		replyTo: reply,
+	}
+	select {
+	case panicFmtChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
 }
 
 // Exitf logs a formatted message followed by os.Exit(exitCode)
 func Exitf(exitCode int, format string, a ...interface{}) {
-	exitIF(exitCode, fmt.Sprintf(format, a...))
+	exitIF(exitCode, fmt.Sprintf(format, a...), nil)
 }
 
 // Panicf logs a formatted message followed by a stack trace; flushes and closes the logIF file and
 // then performs os.Exit(1)
 func Panicf(format string, a ...interface{}) {
-	panicIF(fmt.Sprintf(format, a...), getPanicStackTrace())
+	panicIF(fmt.Sprintf(format, a...), getPanicStackTrace(), nil)
 }
 
 // Warningf logs a formatted message with priority Warning.
 func Warningf(format string, a ...interface{}) {
-	logIF(WARNING, format, a)
+	logIF(WARNING, format, a, nil)
 }
 
 // Infof logs a formatted message with priority Info.
 func Infof(format string, a ...interface{}) {
-	logIF(INFO, format, a)
+	logIF(INFO, format, a, nil)
 }
 
 // Debugf logs a formatted message with priority Debug.
 func Debugf(format string, a ...interface{}) {
-	logIF(DEBUG, format, a)
+	logIF(DEBUG, format, a, nil)
+}
+
+// Tracef logs a formatted message with priority Trace.
+func Tracef(format string, a ...interface{}) {
+	logIF(TRACE, format, a, nil)
 }
 
 // Exit logs a message followed by os.Exit(exitCode)
 func Exit(exitCode int, msg string) {
-	exitIF(exitCode, msg)
+	exitIF(exitCode, msg, nil)
 }
 
 // Panic logs a message followed by a stack trace; flushes and closes the logIF file and
 // then performs os.Exit(1)
 func Panic(msg string) {
-	panicIF(msg, getPanicStackTrace())
+	panicIF(msg, getPanicStackTrace(), nil)
 }
 
 // Warning logs a message with priority Warning.
 func Warning(msg string) {
-	logIF(WARNING, msg, nil)
+	msgIF(WARNING, msg, nil)
 }
 
 // Info logs a message with priority Info.
 func Info(msg string) {
-	logIF(INFO, msg, nil)
+	msgIF(INFO, msg, nil)
 }
 
 // Debug logs a message with priority Debug.
 func Debug(msg string) {
-	logIF(DEBUG, msg, nil)
+	msgIF(DEBUG, msg, nil)
+}
+
+// Trace logs a message with priority Trace.
+func Trace(msg string) {
+	msgIF(TRACE, msg, nil)
+}
+
+// WarningDepth logs a message with priority Warning from the caller skip frames above the
+// caller of WarningDepth. WarningDepth(0, msg) is Warning(msg). A function that wraps the
+// logger passes 1 to attribute the message to its caller.
+func WarningDepth(skip int, msg string) {
+	msgSkipIF(skip, WARNING, msg, nil)
+}
+
+// InfoDepth logs a message with priority Info from the caller skip frames above the caller
+// of InfoDepth. See WarningDepth.
+func InfoDepth(skip int, msg string) {
+	msgSkipIF(skip, INFO, msg, nil)
+}
+
+// DebugDepth logs a message with priority Debug from the caller skip frames above the caller
+// of DebugDepth. See WarningDepth.
+func DebugDepth(skip int, msg string) {
+	msgSkipIF(skip, DEBUG, msg, nil)
+}
+
+// TraceDepth logs a message with priority Trace from the caller skip frames above the caller
+// of TraceDepth. See WarningDepth.
+func TraceDepth(skip int, msg string) {
+	msgSkipIF(skip, TRACE, msg, nil)
+}
+
+// DebugFunc logs the message returned by f with priority Debug. f is only called if DEBUG
+// messages are enabled.
+func DebugFunc(f func() string) {
+	if Enabled(DEBUG) {
+		msgIF(DEBUG, f(), nil)
+	}
+}
+
+/*
+Enabled returns true if messages of priority p are logged by the current configuration, e.g. to
+skip the construction of an expensive message:
+
+	if log.Enabled(log.DEBUG) {
+		log.Debugf("state: %s", state.Dump())
+	}
+
+The configuration may change between Enabled and the following log call, e.g. when the log
+config file is re-read, in which case the message is logged or discarded according to the
+configuration at the time of the log call. Suppressed files are not considered by Enabled.
+*/
+func Enabled(p Priority) bool {
+	if isDisabled() {
+		return false
+	}
+	reply := make(chan bool)
+	msg := &enabledMsg{
+		priority: p,
+		replyTo:  reply,
+	}
+	select {
+	case enabledChan <- msg:
+		return <-reply
+	case <-closedChan:
+		return false
+	}
 }
 
-// GetConfig returns the current logger configuration
+// GetConfig returns the current logger configuration, or the configuration at the time of
+// Close after the logger has been closed
 func GetConfig() *Config {
 	reply := make(chan *Config)
-	getConfigChan <- reply
+	select {
+	case getConfigChan <- reply:
+	case <-closedChan:
+		return closedConfig.Clone()
+	}
 	select {
 	case c := <-reply:
 		return c
@@ -280,24 +771,190 @@ func GetConfig() *Config {
 	}
 }
 
+// Init initialises the logger with cfg instead of the log config file. cfg should be obtained
+// from DefaultConfig() and then modified. If cfg.FileName is empty the name of the executable
+// is used.
+//
+// Init creates cfg.RootDir and opens the first log file before returning. If either fails Init
+// returns the error and the logger is left unchanged. The log config file is not read by a
+// logger initialised by Init.
+//
+// If Init is not called the logger initialises itself from the log config file when it is
+// first used. If it cannot create its log files, e.g. because RootDir is not writable, it
+// reports the error on os.Stderr and writes the log to os.Stderr instead. A program that must
+// not fall back to os.Stderr, or that chooses another directory, calls Init and handles its
+// error. Errors reading the log config file and errors writing the log are also reported on
+// os.Stderr rather than panicking.
+func Init(cfg *Config) error {
+	reply := make(chan error)
+	msg := &initMsg{
+		cfg:     cfg.Clone(),
+		replyTo: reply,
+	}
+	select {
+	case initChan <- msg:
+		return <-reply
+	case <-closedChan:
+		return ErrClosed
+	}
+}
+
 // SetConfig sets the configuration of the logger to priority, to use up to maxFiles files and to close
 // files that exceed maxBytes
 func SetConfig(maxFiles, maxBytes int, priority Priority) {
-	setConfigChan <- &configMsg{
+	reply := make(chan bool)
+	msg := &configMsg{
 		maxFiles: maxFiles,
 		maxBytes: maxBytes,
 		priority: priority,
+		replyTo:  reply,
+	}
+	select {
+	case setConfigChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// SetLevelForDuration sets the priority of the logger to priority for duration d, after which
+// the logger reverts to its previous priority, e.g.: to log DEBUG messages for a minute during
+// an incident. Calling SetLevelForDuration again before d has passed extends the temporary
+// priority until its new duration has passed. SetConfig and Init cancel the reversion.
+func SetLevelForDuration(priority Priority, d time.Duration) {
+	reply := make(chan bool)
+	msg := &levelMsg{
+		priority: priority,
+		duration: d,
+		replyTo:  reply,
+	}
+	select {
+	case levelChan <- msg:
+		<-reply
+	case <-closedChan:
 	}
 }
 
-// Suppress sets the list of files whose Debug messages are suppressed.Suppressed.
+// Suppress sets the list of files whose Debug and Trace messages are suppressed.
 // If files is an empty string no files are suppressed.
 // files is a comma separated list of file names.
-// File names must not have a path.
+// A file name without a path matches the files of that name in all packages. A file name with
+// a package path, e.g. "github.com/me/app/auth/util", matches the file in packages whose path
+// ends in the package path of the name.
 // The ".go" extensions of the file names may be omitted.
-//     E.g.: "file1,file2"
+//     E.g.: "file1,file2,github.com/me/app/auth/util"
 func Suppress(files string) {
-	suppressChan <- files
+	reply := make(chan bool)
+	msg := &suppressMsg{
+		files:   files,
+		replyTo: reply,
+	}
+	select {
+	case suppressChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// Statistics contains the statistics of the logger returned by Stats
+type Statistics struct {
+	// Dropped is the number of discarded messages of each priority with discarded messages,
+	// e.g.: messages logged after Close.
+	Dropped map[Priority]uint64
+	// Files contains the statistics of the open log files: the default log files followed by
+	// the log files of the routes in the order of Config.Routes. Files is empty if the logger
+	// has no open log files, e.g. if SetOutput was called before any log files were created.
+	Files []*files.Stats
+}
+
+// Stats returns the statistics of the logger since the program started and the current
+// statistics of its log files, e.g. for a health endpoint:
+//     for _, st := range log.Stats().Files {
+//         fmt.Fprintf(w, "%s: %d of %d bytes\n", st.CurrentFile, st.CurrentFileSize, st.MaxFileSize)
+//     }
+func Stats() *Statistics {
+	stats := &Statistics{Dropped: make(map[Priority]uint64)}
+	for p := range dropped {
+		if n := atomic.LoadUint64(&dropped[p]); n > 0 {
+			stats.Dropped[Priority(p)] = n
+		}
+	}
+	reply := make(chan []*files.Stats)
+	select {
+	case fileStatsChan <- reply:
+		stats.Files = <-reply
+	case <-closedChan:
+		// the log files have been closed
+	}
+	return stats
+}
+
+// EnableDebugFor logs the Debug and Trace messages of only the files in the comma separated
+// list files, e.g.: "parser,lexer", if the logger priority is DEBUG or TRACE. The file names are
+// matched like the file names of Suppress, and a file that is suppressed by Suppress or
+// SuppressRegex remains suppressed. EnableDebugFor("") enables the messages of all files.
+func EnableDebugFor(files string) {
+	reply := make(chan bool)
+	msg := &suppressMsg{
+		files:   files,
+		replyTo: reply,
+	}
+	select {
+	case debugFilesChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+}
+
+// SuppressRegex suppresses the Debug and Trace messages of the files whose base names, e.g.
+// "handler_auth.go", match any of the regular expressions patterns, in addition to the files of
+// Suppress. SuppressRegex returns an error, and leaves the suppression unchanged, if a pattern
+// is not a valid regular expression. SuppressRegex() removes the patterns.
+//     E.g.: log.SuppressRegex(`^handler_.*\.go$`)
+func SuppressRegex(patterns ...string) error {
+	regexps := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Error in suppression pattern %q: %s", pattern, err)
+		}
+		regexps[i] = re
+	}
+	reply := make(chan bool)
+	msg := &suppressRegexMsg{
+		regexps: regexps,
+		replyTo: reply,
+	}
+	select {
+	case suppressRxChan <- msg:
+		<-reply
+	case <-closedChan:
+	}
+	return nil
+}
+
+// SuppressionStats returns the number of DEBUG and TRACE messages suppressed per file name (see Suppress)
+// since the last call to ResetSuppressionStats.
+func SuppressionStats() map[string]uint64 {
+	return suppressionStats(false)
+}
+
+// ResetSuppressionStats sets the number of suppressed messages of all files to zero.
+func ResetSuppressionStats() {
+	suppressionStats(true)
+}
+
+func suppressionStats(reset bool) map[string]uint64 {
+	reply := make(chan map[string]uint64)
+	msg := &suppressionStatsMsg{
+		reset:   reset,
+		replyTo: reply,
+	}
+	select {
+	case statsChan <- msg:
+		return <-reply
+	case <-closedChan:
+		return map[string]uint64{}
+	}
 }
 
 func getPanicStackTrace() string {
@@ -0,0 +1,1223 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccmack/goutil/log/files"
+)
+
+func TestPriorityJSON(t *testing.T) {
+	for p := EXIT; p <= TRACE; p++ {
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != `"`+p.String()+`"` {
+			t.Errorf("%s marshalled to %s", p, data)
+		}
+		var p1 Priority
+		if err := json.Unmarshal(data, &p1); err != nil {
+			t.Fatal(err)
+		}
+		if p1 != p {
+			t.Errorf("%s unmarshalled to %s", data, p1)
+		}
+	}
+}
+
+func TestPriorityUnmarshalJSON(t *testing.T) {
+	var s struct {
+		P Priority
+	}
+	if err := json.Unmarshal([]byte(`{"P": 4}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.P != DEBUG {
+		t.Errorf("expected DEBUG, got %s", s.P)
+	}
+	if err := json.Unmarshal([]byte(`{"P": "warning"}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.P != WARNING {
+		t.Errorf("expected WARNING, got %s", s.P)
+	}
+	for _, in := range []string{`{"P": 9}`, `{"P": "LOUD"}`, `{"P": true}`} {
+		if err := json.Unmarshal([]byte(in), &s); err == nil {
+			t.Errorf("expected error unmarshalling %s", in)
+		}
+	}
+}
+
+// tempLogDir returns a new temporary log directory
+func tempLogDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestInit(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+
+	// A regular file cannot be the parent of the log directory
+	notDir := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.RootDir = filepath.Join(notDir, "logs")
+	cfg.FileName = "init"
+	if err := Init(cfg); err == nil {
+		t.Fatalf("expected error from Init with RootDir %s", cfg.RootDir)
+	}
+
+	cfg.RootDir = filepath.Join(dir, "logs")
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if c := GetConfig(); c.RootDir != cfg.RootDir || c.FileName != "init" {
+		t.Errorf("config %s after Init", c)
+	}
+	Info("after Init")
+	Flush()
+	if log := readLog(t, cfg.RootDir, "init"); !strings.Contains(log, "after Init") {
+		t.Errorf("message missing from log:\n%s", log)
+	}
+}
+
+func TestSuppressionStats(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "suppress"
+	cfg.Priority = DEBUG
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ResetSuppressionStats()
+
+	Suppress("interface_test")
+	for i := 0; i < 5; i++ {
+		Debugf("suppressed %d", i)
+	}
+	Info("not suppressed")
+	stats := SuppressionStats()
+	if len(stats) != 1 || stats["interface_test.go"] != 5 {
+		t.Errorf("stats %v", stats)
+	}
+
+	ResetSuppressionStats()
+	Debug("suppressed after reset")
+	Suppress("")
+	Debug("not suppressed")
+	stats = SuppressionStats()
+	if len(stats) != 1 || stats["interface_test.go"] != 1 {
+		t.Errorf("stats after reset %v", stats)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = filepath.Join(dir, "main")
+	cfg.FileName = "routes"
+	cfg.Priority = DEBUG
+	cfg.Routes = []*Route{
+		{From: DEBUG, To: DEBUG, RootDir: filepath.Join(dir, "debug")},
+		{From: WARNING, To: WARNING, RootDir: filepath.Join(dir, "warning")},
+	}
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	Debug("debug message")
+	Info("info message")
+	Warning("warning message")
+	Flush()
+
+	tests := []struct {
+		dir, name, level string
+	}{
+		{"main", "routes", "[INFO]"},
+		{"debug", "routes.debug", "[DEBUG]"},
+		{"warning", "routes.warning", "[WARNING]"},
+	}
+	for _, test := range tests {
+		log := readLog(t, filepath.Join(dir, test.dir), test.name)
+		if !strings.Contains(log, test.level) {
+			t.Errorf("%s missing from %s:\n%s", test.level, test.name, log)
+		}
+		for _, level := range []string{"[INFO]", "[DEBUG]", "[WARNING]"} {
+			if level != test.level && strings.Contains(log, level) {
+				t.Errorf("%s in %s:\n%s", level, test.name, log)
+			}
+		}
+	}
+}
+
+func TestRouteJSON(t *testing.T) {
+	jc := new(jsonConfig)
+	data := `{"Routes": [{"From": "PANIC", "To": "WARNING", "RootDir": "problems"}]}`
+	if err := json.Unmarshal([]byte(data), jc); err != nil {
+		t.Fatal(err)
+	}
+	c := jsonToConfig(jc)
+	if len(c.Routes) != 1 {
+		t.Fatalf("routes %v", c.Routes)
+	}
+	r := c.Routes[0]
+	if r.Name() != "panic-warning" || !r.Covers(PANIC) || !r.Covers(WARNING) || r.Covers(INFO) {
+		t.Errorf("route %s", r)
+	}
+	if !c.Equal(c.Clone()) {
+		t.Error("clone not equal")
+	}
+}
+
+func TestRefreshIntervalJSON(t *testing.T) {
+	for data, exp := range map[string]time.Duration{
+		`{}`:                         DefaultRefreshInterval,
+		`{"RefreshInterval": 0}`:     DefaultRefreshInterval,
+		`{"RefreshInterval": "1m"}`:  time.Minute,
+		`{"RefreshInterval": 60}`:    time.Minute,
+		`{"RefreshInterval": "5ms"}`: 5 * time.Millisecond,
+	} {
+		jc := new(jsonConfig)
+		if err := json.Unmarshal([]byte(data), jc); err != nil {
+			t.Fatal(err)
+		}
+		c := jsonToConfig(jc)
+		if c.RefreshInterval != exp {
+			t.Errorf("%s: refresh interval %s, expected %s", data, c.RefreshInterval, exp)
+		}
+		if jc1 := c.toJSONConfig(); time.Duration(jc1.RefreshInterval) != exp {
+			t.Errorf("%s: %s in JSON config", data, time.Duration(jc1.RefreshInterval))
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"RefreshInterval": "soon"}`), new(jsonConfig)); err == nil {
+		t.Error("no error for invalid refresh interval")
+	}
+}
+
+func TestFileModeJSON(t *testing.T) {
+	for data, exp := range map[string]os.FileMode{
+		`{}`:                   0,
+		`{"FileMode": "0640"}`: 0640,
+		`{"FileMode": "640"}`:  0640,
+		`{"FileMode": 416}`:    0640,
+	} {
+		jc := new(jsonConfig)
+		if err := json.Unmarshal([]byte(data), jc); err != nil {
+			t.Fatal(err)
+		}
+		c := jsonToConfig(jc)
+		if c.FileMode != exp {
+			t.Errorf("%s: file mode %s, expected %s", data, c.FileMode, exp)
+		}
+		buf, err := json.Marshal(c.toJSONConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp != 0 && !strings.Contains(string(buf), `"FileMode":"0640"`) {
+			t.Errorf("%s: JSON config %s", data, buf)
+		}
+	}
+	for _, data := range []string{`{"FileMode": "rw"}`, `{"DirMode": "01777"}`, `{"DirMode": -1}`} {
+		if err := json.Unmarshal([]byte(data), new(jsonConfig)); err == nil {
+			t.Errorf("no error for %s", data)
+		}
+	}
+}
+
+// helperEnv is set in the environment of a test run in a child process by runHelper
+const helperEnv = "GOUTIL_LOG_TEST_HELPER"
+
+// runHelper runs test in a child process with helperEnv set to dir, and returns its output and
+// exit error. The test must be a helper that checks helperEnv before running.
+func runHelper(t *testing.T, test, dir string) ([]byte, error) {
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$")
+	cmd.Env = append(os.Environ(), helperEnv+"="+dir)
+	return cmd.CombinedOutput()
+}
+
+// initHelper initialises the logger of a child process started by runHelper
+func initHelper(name string) {
+	cfg := DefaultConfig()
+	cfg.RootDir = os.Getenv(helperEnv)
+	cfg.FileName = name
+	if err := Init(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(10)
+	}
+}
+
+func TestFlushAndCloseHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	initHelper("flush")
+	for i := 0; i < 100; i++ {
+		Infof("message %d", i)
+	}
+	if err := FlushAndClose(time.Second); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(11)
+	}
+	// Logging after closing is discarded
+	Info("after close")
+	if n := Stats().Dropped[INFO]; n != 1 {
+		fmt.Fprintln(os.Stderr, n, "dropped INFO messages")
+		os.Exit(14)
+	}
+	start := time.Now()
+	if err := FlushAndClose(50 * time.Millisecond); err == nil {
+		fmt.Fprintln(os.Stderr, "no error closing a closed logger")
+		os.Exit(12)
+	}
+	if d := time.Since(start); d > time.Second {
+		fmt.Fprintln(os.Stderr, "FlushAndClose timeout exceeded", d)
+		os.Exit(13)
+	}
+	os.Exit(0)
+}
+
+func TestFlushAndClose(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestFlushAndCloseHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	log := readLog(t, dir, "flush")
+	for i := 0; i < 100; i++ {
+		if !strings.Contains(log, fmt.Sprintf("message %d\n", i)) {
+			t.Fatalf("message %d missing from log:\n%s", i, log)
+		}
+	}
+	if strings.Contains(log, "after close") {
+		t.Error("message logged after close")
+	}
+}
+
+func TestCloseHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	initHelper("close")
+	for i := 0; i < 1000; i++ {
+		Infof("message %d", i)
+	}
+	start := time.Now()
+	Close()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		fmt.Fprintln(os.Stderr, "Close took", d)
+		os.Exit(10)
+	}
+	if err := CloseTimeout(50 * time.Millisecond); err == nil {
+		fmt.Fprintln(os.Stderr, "no error closing a closed logger")
+		os.Exit(11)
+	}
+	os.Exit(0)
+}
+
+func TestClose(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestCloseHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	log := readLog(t, dir, "close")
+	for i := 0; i < 1000; i++ {
+		if !strings.Contains(log, fmt.Sprintf("message %d\n", i)) {
+			t.Fatalf("message %d missing from log", i)
+		}
+	}
+}
+
+func TestAfterCloseHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	initHelper("after")
+	sink := NewMemorySink()
+	Close()
+	go func() {
+		time.Sleep(5 * time.Second)
+		fmt.Fprintln(os.Stderr, "timeout after Close")
+		os.Exit(10)
+	}()
+	Flush()
+	sink.Lines()
+	sink.Reset()
+	if Enabled(INFO) {
+		fmt.Fprintln(os.Stderr, "INFO enabled after Close")
+		os.Exit(11)
+	}
+	if c := GetConfig(); c.FileName != "after" {
+		fmt.Fprintln(os.Stderr, "config after Close", c)
+		os.Exit(12)
+	}
+	if err := Rotate(); err != ErrClosed {
+		fmt.Fprintln(os.Stderr, "Rotate after Close:", err)
+		os.Exit(13)
+	}
+	RemoveHook(AddHook(func(Priority, string, int, string) {}))
+	SetOutput(sink)
+	Suppress("a")
+	SetConfig(2, 1000, DEBUG)
+	if err := CloseTimeout(time.Second); err != ErrClosed {
+		fmt.Fprintln(os.Stderr, "CloseTimeout after Close:", err)
+		os.Exit(14)
+	}
+	os.Exit(0)
+}
+
+func TestAfterClose(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestAfterCloseHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+}
+
+func TestDisableConfigReload(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "disable"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	DisableConfigReload()
+	SetConfig(5, 2000, WARNING)
+	if c := GetConfig(); c.NumFiles != 5 || c.FileNumBytes != 2000 || c.Priority != WARNING {
+		t.Errorf("config %s after SetConfig", c)
+	}
+}
+
+func TestSetLevelForDuration(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "level"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	SetLevelForDuration(DEBUG, 100*time.Millisecond)
+	if p := GetConfig().Priority; p != DEBUG {
+		t.Fatalf("priority %s after SetLevelForDuration", p)
+	}
+	Debug("temporary debug")
+	time.Sleep(300 * time.Millisecond)
+	if p := GetConfig().Priority; p != INFO {
+		t.Errorf("priority %s after duration", p)
+	}
+	Debug("debug after reversion")
+	Flush()
+	log := readLog(t, dir, "level")
+	if !strings.Contains(log, "temporary debug") || strings.Contains(log, "debug after reversion") {
+		t.Errorf("unexpected log:\n%s", log)
+	}
+
+	// SetConfig cancels the reversion
+	SetLevelForDuration(DEBUG, 100*time.Millisecond)
+	SetConfig(cfg.NumFiles, cfg.FileNumBytes, WARNING)
+	time.Sleep(300 * time.Millisecond)
+	if p := GetConfig().Priority; p != WARNING {
+		t.Errorf("priority %s after SetConfig", p)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "sequence"
+	cfg.Sequence = true
+	cfg.SequenceBase = 100
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	for i := 0; i < n; i++ {
+		Infof("message %d", i)
+		Debug("not written")
+	}
+	Flush()
+
+	next := uint64(100)
+	for _, line := range strings.Split(readLog(t, dir, "sequence"), "\n") {
+		if !strings.Contains(line, "[INFO]") {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(line[strings.Index(line, " seq="):], " seq=%d", &seq); err != nil {
+			t.Fatalf("%s: %q", err, line)
+		}
+		if seq != next {
+			t.Fatalf("seq %d, expected %d: %q", seq, next, line)
+		}
+		next++
+	}
+	if next != 100+n {
+		t.Errorf("%d messages", next-100)
+	}
+}
+
+func TestPanicOrderHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	initHelper("panic")
+	for i := 0; i < 200; i++ {
+		Infof("message %d", i)
+	}
+	Panic("boom")
+}
+
+func TestPanicOrder(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestPanicOrderHelper", dir); err == nil {
+		t.Fatalf("helper did not exit with an error\n%s", out)
+	}
+	log := readLog(t, dir, "panic")
+	i := strings.Index(log, "[PANIC]")
+	if i < 0 {
+		t.Fatalf("panic missing from log:\n%s", log)
+	}
+	for j := 0; j < 200; j++ {
+		k := strings.Index(log, fmt.Sprintf("message %d\n", j))
+		if k < 0 || k > i {
+			t.Fatalf("message %d does not precede the panic in log:\n%s", j, log)
+		}
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	const version = "1.2.3 (4f2a9c1)"
+	SetVersion(version)
+	defer SetVersion("")
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "version"
+	cfg.FileNumBytes = 200
+	cfg.NumFiles = 10
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		Infof("message %d", i)
+	}
+	Flush()
+
+	logFiles := files.ListLogFiles(dir, "version")
+	if len(logFiles) < 2 {
+		t.Fatalf("%d log files", len(logFiles))
+	}
+	for _, fname := range logFiles {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.SplitN(string(data), "\n", 5)
+		if len(lines) < 4 || lines[3] != "Version: "+version {
+			t.Errorf("version missing from header of %s:\n%s", fname, data)
+		}
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "withfields"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	lgr := WithFields(map[string]interface{}{"request_id": 42, "user": "bob"})
+	const n = 10
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			lgr.WithFields(map[string]interface{}{"user": "alice", "worker": i}).Infof("message %d", i)
+			done <- true
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	lgr.Info("plain")
+	Flush()
+
+	log := readLog(t, dir, "withfields")
+	for i := 0; i < n; i++ {
+		exp := fmt.Sprintf("- message %d request_id=42 user=alice worker=%d\n", i, i)
+		if !strings.Contains(log, exp) {
+			t.Errorf("%q missing from log:\n%s", exp, log)
+		}
+	}
+	if !strings.Contains(log, "-interface_test.go, line ") ||
+		!strings.Contains(log, "- plain request_id=42 user=bob\n") {
+		t.Errorf("plain message missing from log:\n%s", log)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "output"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	SetOutput(&buf)
+	Info("to output")
+	SetOutput(nil)
+	Info("to files")
+	Flush()
+
+	if out := buf.String(); !strings.Contains(out, "- to output\n") || strings.Contains(out, "to files") {
+		t.Errorf("output:\n%s", out)
+	}
+	if log := readLog(t, dir, "output"); strings.Contains(log, "to output") ||
+		!strings.Contains(log, "- to files\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestSetOutputCloseHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	f, err := os.Create(filepath.Join(os.Getenv(helperEnv), "output"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(10)
+	}
+	SetOutput(f)
+	for i := 0; i < 100; i++ {
+		Infof("message %d", i)
+	}
+	Close()
+	os.Exit(0)
+}
+
+func TestSetOutputClose(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestSetOutputCloseHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if !strings.Contains(string(data), fmt.Sprintf("message %d\n", i)) {
+			t.Fatalf("message %d missing from output:\n%s", i, data)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "enabled"
+	cfg.Priority = INFO
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled(WARNING) || !Enabled(INFO) || Enabled(DEBUG) {
+		t.Errorf("enabled at priority INFO: %t %t %t", Enabled(WARNING), Enabled(INFO), Enabled(DEBUG))
+	}
+	called := false
+	DebugFunc(func() string {
+		called = true
+		return "not logged"
+	})
+	if called {
+		t.Error("DebugFunc called f at priority INFO")
+	}
+
+	SetConfig(cfg.NumFiles, cfg.FileNumBytes, DEBUG)
+	DebugFunc(func() string { return "expensive" })
+	Flush()
+	if log := readLog(t, dir, "enabled"); !strings.Contains(log, "-interface_test.go, line ") ||
+		!strings.Contains(log, "[DEBUG] -") || !strings.Contains(log, "- expensive\n") {
+		t.Errorf("debug message missing from log:\n%s", log)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "trace"
+	cfg.Priority = DEBUG
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	Trace("below debug")
+	SetConfig(cfg.NumFiles, cfg.FileNumBytes, TRACE)
+	ResetSuppressionStats()
+	Suppress("interface_test")
+	Tracef("suppressed %d", 1)
+	if stats := SuppressionStats(); stats["interface_test.go"] != 1 {
+		t.Errorf("stats %v", stats)
+	}
+	Suppress("")
+	Tracef("traced %d", 2)
+	Flush()
+
+	log := readLog(t, dir, "trace")
+	if strings.Contains(log, "below debug") || strings.Contains(log, "suppressed 1") ||
+		!strings.Contains(log, "[TRACE] -interface_test.go, line ") || !strings.Contains(log, "- traced 2\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+// blockingWriter blocks writes while block is set until release is closed
+type blockingWriter struct {
+	block   int32
+	release chan bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.block) != 0 {
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func TestDropWhenFull(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "drop"
+	cfg.DropWhenFull = true
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer setDropWhenFull(false)
+	w := &blockingWriter{release: make(chan bool)}
+	SetOutput(w)
+	defer SetOutput(nil)
+	drops := Stats().Dropped[INFO]
+
+	atomic.StoreInt32(&w.block, 1)
+	n := 2 * cap(logChan())
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			Infof("message %d", i)
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("logging blocked with DropWhenFull")
+	}
+	close(w.release)
+	if d := Stats().Dropped[INFO] - drops; d < uint64(n-cap(logChan())-1) {
+		t.Errorf("%d messages dropped", d)
+	}
+}
+
+func TestSetBufferSizeHelper(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("helper process")
+	}
+	if err := SetBufferSize(0); err == nil {
+		fmt.Fprintln(os.Stderr, "no error for buffer size 0")
+		os.Exit(10)
+	}
+	if err := SetBufferSize(16); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(11)
+	}
+	if cap(logChan()) != 16 {
+		fmt.Fprintln(os.Stderr, "buffer size", cap(logChan()))
+		os.Exit(12)
+	}
+	initHelper("buffer")
+	if err := SetBufferSize(32); err == nil {
+		fmt.Fprintln(os.Stderr, "no error after the logger has been used")
+		os.Exit(13)
+	}
+	for i := 0; i < 100; i++ {
+		Infof("message %d", i)
+	}
+	if err := FlushAndClose(time.Second); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(14)
+	}
+	os.Exit(0)
+}
+
+func TestSetBufferSize(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	if out, err := runHelper(t, "TestSetBufferSizeHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	log := readLog(t, dir, "buffer")
+	for i := 0; i < 100; i++ {
+		if !strings.Contains(log, fmt.Sprintf("message %d\n", i)) {
+			t.Fatalf("message %d missing from log:\n%s", i, log)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "context"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithContext(context.Background(), map[string]interface{}{"request_id": 7})
+	ctx = WithContext(ctx, map[string]interface{}{"user": "bob"})
+	FromContext(ctx).Infof("handled %d", 1)
+	FromContext(context.Background()).Info("no fields")
+	Flush()
+
+	log := readLog(t, dir, "context")
+	if !strings.Contains(log, "-interface_test.go, line ") ||
+		!strings.Contains(log, "- handled 1 request_id=7 user=bob\n") ||
+		!strings.Contains(log, "- no fields\n") {
+		t.Errorf("log:\n%s", log)
+	}
+	if n := testing.AllocsPerRun(100, func() { FromContext(ctx) }); n != 0 {
+		t.Errorf("%f allocations by FromContext", n)
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "addhook"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var warnings int
+	id := AddHook(func(p Priority, file string, line int, msg string) {
+		if p == WARNING {
+			warnings++
+		}
+	})
+	Warning("one")
+	Info("two")
+	Warningf("three %d", 3)
+	RemoveHook(id)
+	Warning("four")
+	Flush()
+	if warnings != 2 {
+		t.Errorf("%d warnings", warnings)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "flush"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		Infof("message %d", i)
+	}
+	Flush()
+	log := readLog(t, dir, "flush")
+	for i := 0; i < 500; i++ {
+		if !strings.Contains(log, fmt.Sprintf("- message %d\n", i)) {
+			t.Fatalf("message %d missing after Flush", i)
+		}
+	}
+	Info("after flush")
+	Flush()
+	if log := readLog(t, dir, "flush"); !strings.Contains(log, "- after flush\n") {
+		t.Error("logger not usable after Flush")
+	}
+}
+
+func TestSuppressRegex(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "regex"
+	cfg.Priority = DEBUG
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := SuppressRegex("("); err == nil {
+		t.Error("no error for invalid pattern")
+	}
+	if err := SuppressRegex(`^interface_.*\.go$`); err != nil {
+		t.Fatal(err)
+	}
+	Debug("suppressed")
+	if err := SuppressRegex(); err != nil {
+		t.Fatal(err)
+	}
+	Debug("not suppressed")
+	Flush()
+	if log := readLog(t, dir, "regex"); strings.Contains(log, "- suppressed\n") ||
+		!strings.Contains(log, "- not suppressed\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestSuppressPackagePath(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "pkgpath"
+	cfg.Priority = DEBUG
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, files := range []string{
+		"github.com/goccmack/goutil/log/interface_test",
+		"log/interface_test.go",
+		"other/interface_test,interface_test",
+	} {
+		Suppress(files)
+		Debugf("suppressed by %s", files)
+	}
+	Suppress("github.com/goccmack/goutil/other/interface_test")
+	Debug("not suppressed")
+	Suppress("")
+	Flush()
+	log := readLog(t, dir, "pkgpath")
+	if strings.Contains(log, "- suppressed by ") || !strings.Contains(log, "- not suppressed\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "writer"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	w := Writer(WARNING)
+	if n, err := io.WriteString(w, "first 100%\nsecond\n"); err != nil || n != 18 {
+		t.Errorf("Write returned %d, %v", n, err)
+	}
+	stdlog.New(w, "std: ", 0).Print("third")
+	Flush()
+
+	log := readLog(t, dir, "writer")
+	for _, exp := range []string{"] -io.go, line ", "- first 100%\n", "- second\n", "] -log.go, line ", "- std: third\n"} {
+		if !strings.Contains(log, exp) {
+			t.Errorf("%q missing from log:\n%s", exp, log)
+		}
+	}
+}
+
+// logWrapper wraps the logger like an application helper
+func logWrapper(msg string) {
+	InfoDepth(1, msg)
+}
+
+func TestInfoDepth(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "depth"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	_, _, line, _ := runtime.Caller(0)
+	logWrapper("wrapped")
+	InfoDepth(0, "direct")
+	Flush()
+	log := readLog(t, dir, "depth")
+	for _, exp := range []string{
+		fmt.Sprintf("-interface_test.go, line %d- wrapped\n", line+1),
+		fmt.Sprintf("-interface_test.go, line %d- direct\n", line+2),
+	} {
+		if !strings.Contains(log, exp) {
+			t.Errorf("%q missing from log:\n%s", exp, log)
+		}
+	}
+}
+
+func TestHeaderIdentity(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	host, _ := os.Hostname()
+	exp := fmt.Sprintf("\nHost: %s, PID: %d\n", host, os.Getpid())
+	for _, minimal := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.RootDir = dir
+		cfg.FileName = fmt.Sprintf("header_%t", minimal)
+		cfg.MinimalHeader = minimal
+		if err := Init(cfg); err != nil {
+			t.Fatal(err)
+		}
+		Info("message")
+		Flush()
+		if log := readLog(t, dir, cfg.FileName); strings.Contains(log, exp) == minimal {
+			t.Errorf("MinimalHeader %t:\n%s", minimal, log)
+		}
+	}
+}
+
+func TestDisableHelper(t *testing.T) {
+	dir := os.Getenv(helperEnv)
+	if dir == "" {
+		t.Skip("helper process")
+	}
+	os.Setenv(ConfigFileEnv, filepath.Join(dir, "log.config"))
+	Disable()
+	Info("discarded")
+	if Enabled(WARNING) {
+		fmt.Fprintln(os.Stderr, "WARNING enabled after Disable")
+		os.Exit(11)
+	}
+	if cfg := GetConfig(); cfg.RootDir != DefaultLogRootDir {
+		fmt.Fprintln(os.Stderr, "config", cfg)
+		os.Exit(12)
+	}
+	initHelper("disable")
+	Info("enabled")
+	Close()
+	os.Exit(0)
+}
+
+func TestDisable(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := fmt.Sprintf(`{"RootDir": %q}`, filepath.Join(dir, "logs"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "log.config"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runHelper(t, "TestDisableHelper", dir); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs")); !os.IsNotExist(err) {
+		t.Errorf("log directory of log.config created: %v", err)
+	}
+	log := readLog(t, dir, "disable")
+	if strings.Contains(log, "discarded") || !strings.Contains(log, "- enabled\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+// cleaner runs the functions registered by Cleanup when done is called
+type cleaner []func()
+
+func (c *cleaner) Cleanup(f func()) {
+	*c = append(*c, f)
+}
+
+func (c *cleaner) done() {
+	for i := len(*c) - 1; i >= 0; i-- {
+		(*c)[i]()
+	}
+}
+
+func TestMemorySink(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "memory"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	c := new(cleaner)
+	sink := CaptureForTest(c)
+	Info("captured")
+	Warningf("captured %d", 2)
+	if !sink.Contains("[INFO] -interface_test.go, line ") || sink.Count("- captured") != 2 {
+		t.Errorf("lines %q", sink.Lines())
+	}
+	sink.Reset()
+	if lines := sink.Lines(); len(lines) != 0 {
+		t.Errorf("lines after Reset %q", lines)
+	}
+	c.done()
+	Info("not captured")
+	Flush()
+	if sink.Contains("not captured") {
+		t.Error("message captured after cleanup")
+	}
+	if log := readLog(t, dir, "memory"); strings.Contains(log, "- captured") ||
+		!strings.Contains(log, "- not captured\n") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	partial := NewMemorySink()
+	partial.Write([]byte("a\nb"))
+	partial.Write([]byte("c\r\n"))
+	if lines := partial.Lines(); strings.Join(lines, ",") != "a,bc" {
+		t.Errorf("lines %q", lines)
+	}
+}
+
+func TestInitFallbackHelper(t *testing.T) {
+	dir := os.Getenv(helperEnv)
+	if dir == "" {
+		t.Skip("helper process")
+	}
+	os.Setenv(ConfigFileEnv, filepath.Join(dir, "log.config"))
+	Info("to stderr")
+	Close()
+	os.Exit(0)
+}
+
+func TestInitFallback(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	// a log directory below a regular file cannot be created
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf(`{"RootDir": %q}`, filepath.Join(dir, "file", "logs"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "log.config"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runHelper(t, "TestInitFallbackHelper", dir)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Error creating log files: ") ||
+		!strings.Contains(string(out), "- to stderr\n") {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "percent"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	Info("100% done")
+	Infof("%d%% done", 99)
+	// a format without arguments is formatted
+	Infof("100%% done")
+	WithFields(map[string]interface{}{"k": 1}).Info("10% off")
+	Flush()
+	log := readLog(t, dir, "percent")
+	if strings.Count(log, "- 100% done\n") != 2 || !strings.Contains(log, "- 99% done\n") ||
+		!strings.Contains(log, "- 10% off") || strings.Contains(log, "%%") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "rotate"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	Info("before")
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// the header of the second file is written by the file set, so the file counts as empty
+	if err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	Info("after")
+	Flush()
+	logFiles := files.ListLogFiles(dir, "rotate")
+	if len(logFiles) != 2 {
+		t.Fatalf("%d log files: %v", len(logFiles), logFiles)
+	}
+	for i, exp := range []string{"- before\n", "- after\n"} {
+		data, err := ioutil.ReadFile(logFiles[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), exp) {
+			t.Errorf("%q missing from log file %d:\n%s", exp, i, data)
+		}
+	}
+}
+
+func TestFileStats(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "stats"
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	Info("stats")
+	Flush()
+	stats := Stats().Files
+	if len(stats) != 1 {
+		t.Fatalf("%d file stats", len(stats))
+	}
+	if st := stats[0]; !strings.HasPrefix(filepath.Base(st.CurrentFile), "stats_") ||
+		st.CurrentFileSize == 0 || st.NumFiles != 1 || st.TotalBytes <= int64(st.CurrentFileSize) {
+		t.Errorf("file stats %+v", st)
+	}
+}
@@ -15,39 +15,90 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/goccmack/goutil/log/files"
 )
 
 /*** Interface to logger ***/
 
+// DefaultBufferSize is the default number of log messages buffered for the logger
+const DefaultBufferSize = 1024
+
 var (
-	closeChan     = make(chan bool)
-	exitChan      = make(chan *exitMsg)
-	getConfigChan = make(chan chan *Config)
-	logChan       = make(chan *logMsg, 1024)
-	panicChan     = make(chan *panicMsg)
-	setConfigChan = make(chan *configMsg)
-	suppressChan  = make(chan string)
+	bufferSizeChan = make(chan *bufferSizeMsg)
+	closeChan      = make(chan chan error)
+	closedChan     = make(chan struct{})
+	debugFilesChan = make(chan *suppressMsg)
+	disableChan    = make(chan chan bool)
+	disableLogChan = make(chan chan bool)
+	enabledChan    = make(chan *enabledMsg)
+	exitChan       = make(chan *exitMsg)
+	fileStatsChan  = make(chan chan []*files.Stats)
+	flushChan      = make(chan chan bool)
+	getConfigChan  = make(chan chan *Config)
+	hookChan       = make(chan *hookMsg)
+	initChan       = make(chan *initMsg)
+	levelChan      = make(chan *levelMsg)
+	panicChan      = make(chan *panicMsg)
+	outputChan     = make(chan *outputMsg)
+	panicFmtChan   = make(chan *panicFormatterMsg)
+	rateLimitChan  = make(chan *rateLimitMsg)
+	rotateChan     = make(chan chan error)
+	rotateOnChan   = make(chan *rotateOnMsg)
+	versionChan    = make(chan *versionMsg)
+	setConfigChan  = make(chan *configMsg)
+	suppressChan   = make(chan *suppressMsg)
+	suppressRxChan = make(chan *suppressRegexMsg)
+	statsChan      = make(chan *suppressionStatsMsg)
 )
 
+type bufferSizeMsg struct {
+	size    int
+	replyTo chan error
+}
+
 type configMsg struct {
 	maxFiles int
 	maxBytes int
 	priority Priority
+	replyTo  chan bool
+}
+
+type enabledMsg struct {
+	priority Priority
+	replyTo  chan bool
 }
 
 type exitMsg struct {
+	pc       uintptr
 	file     string
 	line     int
 	exitCode int
 	msg      string
+	fields   []*field
+}
+
+// hookMsg adds hook f, or removes the hook with id if f is nil
+type hookMsg struct {
+	f       Hook
+	id      HookID
+	replyTo chan HookID
+}
+
+type initMsg struct {
+	cfg     *Config
+	replyTo chan error
 }
 
 type isSuppressedMsg struct {
@@ -55,38 +106,184 @@ type isSuppressedMsg struct {
 	replyTo  chan bool
 }
 
+type suppressRegexMsg struct {
+	regexps []*regexp.Regexp
+	replyTo chan bool
+}
+
+type suppressMsg struct {
+	files   string
+	replyTo chan bool
+}
+
+type suppressionStatsMsg struct {
+	reset   bool
+	replyTo chan map[string]uint64
+}
+
+type levelMsg struct {
+	priority Priority
+	duration time.Duration
+	replyTo  chan bool
+}
+
 type logMsg struct {
+	pc       uintptr
 	file     string
 	line     int
 	priority Priority
 	format   string
 	a        []interface{}
+	// verbatim is set if format is the message, e.g. of Info, rather than a format
+	verbatim bool
+	fields   []*field
+}
+
+type outputMsg struct {
+	w       io.Writer
+	replyTo chan bool
+}
+
+type panicFormatterMsg struct {
+	f       PanicFormatter
+	replyTo chan bool
+}
+
+type rateLimitMsg struct {
+	maxPerSecond int
+	replyTo      chan bool
+}
+
+type rotateOnMsg struct {
+	predicate func(Entry) bool
+	replyTo   chan bool
+}
+
+type versionMsg struct {
+	version string
+	replyTo chan bool
 }
 
 type panicMsg struct {
+	pc         uintptr
 	file       string
 	line       int
 	msg        string
 	stacktrace string
+	fields     []*field
 }
 
 type logger struct {
 	cfg *Config
 	wtr *files.FileSet
+	// out, if it is not nil, replaces wtr and the routes of cfg. It is set by SetOutput.
+	out io.Writer
+	// routes[i] are the log files of cfg.Routes[i]
+	routes []*files.FileSet
+	// reload is true if cfg was read from the log config file, which is then re-read periodically
+	reload bool
+	// reloadDisabled is set by DisableConfigReload
+	reloadDisabled bool
+	// writeErr is the error of the last write of the log. A write error is reported on os.Stderr
+	// once until a write succeeds.
+	writeErr error
+	// cfgFile is the state of the log config file when it was last read
+	cfgFile *configFileState
+	// watcher watches watchDir, the directory of the log config file, for changes of the file.
+	// watchFailed is set if the directory cannot be watched, in which case refreshTicker checks
+	// the log config file for changes every refreshInterval.
+	watcher         *fsnotify.Watcher
+	watchDir        string
+	watchFailed     bool
+	refreshTicker   *time.Ticker
+	refreshInterval time.Duration
+	// number of suppressed messages per file name since the last reset
+	suppressed map[string]uint64
+	// suppressRegexps are set by SuppressRegex
+	suppressRegexps []*regexp.Regexp
+	// fields are the fields of cfg.Identity and cfg.Fields added to every message, and
+	// fieldsText their text format
+	fields     []*field
+	fieldsText string
+	// seq is the sequence number of the next message if cfg.Sequence is set
+	seq uint64
+	// panicFormatter formats PANIC messages if it is not nil
+	panicFormatter PanicFormatter
+	// version is written to the header of every log file if it is not empty
+	version string
+	// rotateOn, if it is not nil, selects the messages that start a new log file
+	rotateOn func(Entry) bool
+	// revertTimer reverts the priority to revertTo when it fires. It is set by SetLevelForDuration.
+	revertTimer *time.Timer
+	revertTo    Priority
+	// rateLimit is the maximum number of messages per second of each call site, or 0 if the
+	// messages are not rate limited. rates are the token buckets of the call sites, and
+	// rateTicker triggers the summaries of the rate limited messages.
+	rateLimit  int
+	rates      map[callSite]*rateBucket
+	rateTicker *time.Ticker
+	// last is the last message written if cfg.Dedup is set. repeatTimer triggers the message
+	// with the number of held back repeats of last.
+	last        *lastMsg
+	repeatTimer *time.Timer
+	// hooks are called for every message written
+	hooks      []*hook
+	nextHookID HookID
 }
 
+var (
+	// dropped[p] is the number of messages of priority p discarded by logIF
+	dropped [TRACE + 1]uint64
+	// dropWhenFull is 1 if Config.DropWhenFull is set. It is read by logIF.
+	dropWhenFull int32
+	// disabled is 1 after Disable until the logger is initialised by Init. It is read by logIF.
+	disabled int32
+	// logBuf holds the message buffer of the logger, a chan *logMsg. It is replaced by
+	// SetBufferSize while other goroutines may read it, see logChan.
+	logBuf atomic.Value
+	// closedConfig is the configuration of the logger when it was closed. It is set before
+	// closedChan is closed and returned by GetConfig after Close.
+	closedConfig *Config
+)
+
+var (
+	// consoleErr and consoleOut are the console streams of Config.Console
+	consoleErr io.Writer = os.Stderr
+	consoleOut io.Writer = os.Stdout
+)
+
+var (
+	// hostname and pid identify the process in the log file headers and the Identity fields
+	hostname = getHostname()
+	pid      = os.Getpid()
+)
+
 func init() {
+	logBuf.Store(make(chan *logMsg, DefaultBufferSize))
 	go new(logger).run()
 }
 
+// logChan returns the message buffer of the logger
+func logChan() chan *logMsg {
+	return logBuf.Load().(chan *logMsg)
+}
+
 // exitIF is called from the logger interface routines
-func exitIF(exitCode int, msg string) {
-	file, line := getFileLine()
-	exitChan <- &exitMsg{
+func exitIF(exitCode int, msg string, fields []*field) {
+	pc, file, line := getCaller(0)
+	em := &exitMsg{
 		exitCode: exitCode,
 		msg:      msg,
+		pc:       pc,
 		file:     file,
 		line:     line,
+		fields:   fields,
+	}
+	select {
+	case exitChan <- em:
+	case <-closedChan:
+		// the message cannot be logged after Close
+		os.Exit(exitCode)
 	}
 
 	// wait for os.Exit()
@@ -95,27 +292,82 @@ func exitIF(exitCode int, msg string) {
 	}
 }
 
-// logIF is called from the logger interface routines
-func logIF(priority Priority, format string, a []interface{}) {
-	lm := &logMsg{
+// logIF is called from the logger interface routines of formatted messages, e.g. Infof
+func logIF(priority Priority, format string, a []interface{}, fields []*field) {
+	logSkipIF(1, priority, format, a, fields)
+}
+
+// msgIF is called from the logger interface routines of messages that are not formats, e.g.
+// Info
+func msgIF(priority Priority, msg string, fields []*field) {
+	msgSkipIF(1, priority, msg, fields)
+}
+
+// logSkipIF logs a formatted message from the caller skip frames above the caller of the logger
+// interface routine that calls logSkipIF
+func logSkipIF(skip int, priority Priority, format string, a []interface{}, fields []*field) {
+	if isDisabled() {
+		return
+	}
+	queueLogMsg(skip, &logMsg{
 		priority: priority,
 		format:   format,
 		a:        a,
+		fields:   fields,
+	})
+}
+
+// msgSkipIF logs the message msg verbatim from the caller skip frames above the caller of the
+// logger interface routine that calls msgSkipIF
+func msgSkipIF(skip int, priority Priority, msg string, fields []*field) {
+	if isDisabled() {
+		return
 	}
-	lm.file, lm.line = getFileLine()
+	queueLogMsg(skip, &logMsg{
+		priority: priority,
+		format:   msg,
+		verbatim: true,
+		fields:   fields,
+	})
+}
+
+// queueLogMsg sets the caller of lm, skip frames above the caller of the logger interface
+// routine, and queues lm for the logger
+func queueLogMsg(skip int, lm *logMsg) {
+	// queueLogMsg is called one frame below the interface routine
+	lm.pc, lm.file, lm.line = getCaller(skip + 1)
 
-	defer recover()
-	logChan <- lm
+	// logChan is closed when the logger has closed
+	defer func() {
+		if recover() != nil {
+			atomic.AddUint64(&dropped[lm.priority], 1)
+		}
+	}()
+	if atomic.LoadInt32(&dropWhenFull) == 0 {
+		logChan() <- lm
+		return
+	}
+	select {
+	case logChan() <- lm:
+	default:
+		atomic.AddUint64(&dropped[lm.priority], 1)
+	}
 }
 
 // panicIF is called from the logger interface routines
-func panicIF(msg string, stackTrace string) {
+func panicIF(msg string, stackTrace string, fields []*field) {
 	pm := &panicMsg{
 		msg:        msg,
 		stacktrace: stackTrace,
+		fields:     fields,
+	}
+	pm.pc, pm.file, pm.line = getCaller(0)
+	select {
+	case panicChan <- pm:
+	case <-closedChan:
+		// the message cannot be logged after Close
+		os.Exit(1)
 	}
-	pm.file, pm.line = getFileLine()
-	panicChan <- pm
 
 	// wait for os.Exit(1)
 	for {
@@ -125,123 +377,950 @@ func panicIF(msg string, stackTrace string) {
 
 /*** logger class ***/
 
-func (l *logger) close() {
-	close(logChan)
+// autoInit initialises l from the log config file, unless l has already been initialised.
+func (l *logger) autoInit() {
+	if l.cfg != nil {
+		return
+	}
+	l.cfgFile = statConfigFile()
+	l.initOrStderr(readConfigFile(true))
+	l.reload = true
+}
+
+// initOrStderr initialises l with cfg. If the log files of cfg cannot be created the error is
+// reported on os.Stderr and l is configured with cfg writing the log to os.Stderr instead.
+func (l *logger) initOrStderr(cfg *Config) {
+	err := l.init(cfg)
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error creating log files: %s. Logging to stderr\n", err)
+	if l.cfg == nil {
+		l.cfg = cfg
+		l.setFields()
+		setDropWhenFull(cfg.DropWhenFull)
+		l.seq = cfg.SequenceBase
+	}
+	l.out = os.Stderr
+	l.logConfig()
+}
+
+// caller returns the source file path, file, formatted according to the caller style of l
+func (l *logger) caller(file string) string {
+	switch l.cfg.CallerStyle {
+	case CallerFull:
+		return file
+	case CallerPackage:
+		dir, fname := path.Split(file)
+		_, pkg := path.Split(strings.TrimSuffix(dir, "/"))
+		return path.Join(pkg, fname)
+	}
+	_, fname := path.Split(file)
+	return fname
+}
+
+// close writes all pending messages and then syncs and closes the log files of l.
+// close returns the first error encountered.
+func (l *logger) close() error {
+	if len(logChan()) > 0 {
+		l.autoInit()
+	}
+	close(logChan())
+	l.stopWatch()
+	if l.cfg == nil {
+		return nil
+	}
 	l.flushLogMsgs()
-	l.wtr.Close()
+	l.logRateSummaries()
+	var err error
+	for _, wtr := range l.fileSets() {
+		if err1 := wtr.Sync(); err == nil {
+			err = err1
+		}
+		if err1 := wtr.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// fileSets returns the open log files of l: the default log files followed by the log files
+// of the routes.
+func (l *logger) fileSets() []*files.FileSet {
+	if l.wtr == nil {
+		return nil
+	}
+	return append([]*files.FileSet{l.wtr}, l.routes...)
+}
+
+// fileStats returns the statistics of the open log files of l, in the order of l.fileSets
+func (l *logger) fileStats() []*files.Stats {
+	var stats []*files.Stats
+	for _, wtr := range l.fileSets() {
+		if st, err := wtr.Stats(); err == nil {
+			stats = append(stats, st)
+		}
+	}
+	return stats
+}
+
+func closeRoutes(routes []*files.FileSet) {
+	for _, wtr := range routes {
+		wtr.Close()
+	}
 }
 
 func (l *logger) flushLogMsgs() {
-	n := len(logChan)
+	n := len(logChan())
 	for i := 0; i < n; i++ {
-		lm := <-logChan
-		l.logMsg(lm.file, lm.line, lm.priority, lm.format, lm.a, "")
+		lm := <-logChan()
+		l.logMsg(lm.pc, lm.file, lm.line, lm.priority, lm.format, lm.a, lm.verbatim, "", lm.fields)
+	}
+	l.flushRepeats()
+}
+
+// init creates the log directories and first log files of cfg and replaces the configuration and
+// log files of l by them. l is unchanged if an error is returned.
+func (l *logger) init(cfg *Config) error {
+	if cfg.FileName == "" {
+		cfg.FileName = fileName
+	}
+	cfg.TimeFormat = checkTimeFormat(cfg.TimeFormat)
+	wtr, err := files.Open(filesConfig(cfg, l.header(cfg)))
+	if err != nil {
+		return err
 	}
+	routes, err := openRoutes(cfg, l.header(cfg))
+	if err != nil {
+		wtr.Close()
+		return err
+	}
+	if l.cfg != nil {
+		l.flushLogMsgs()
+	}
+	if l.wtr != nil {
+		l.wtr.Close()
+		closeRoutes(l.routes)
+	}
+	l.cfg, l.wtr, l.routes, l.reload = cfg, wtr, routes, false
+	atomic.StoreInt32(&disabled, 0)
+	l.stopRevert()
+	l.setFields()
+	setDropWhenFull(cfg.DropWhenFull)
+	l.seq = cfg.SequenceBase
+	l.logConfig()
+	return nil
 }
 
-func (l *logger) isSuppressed(file string, priority Priority) bool {
+// setOutput directs all messages of l to w, or to the log files of l if w is nil. If l has not
+// been initialised and w is not nil, l is configured from the log config file without creating
+// any log files.
+func (l *logger) setOutput(w io.Writer) {
+	if l.cfg == nil {
+		if w == nil {
+			return
+		}
+		l.cfgFile = statConfigFile()
+		l.cfg, l.reload, l.out = readConfigFile(true), true, w
+		l.setFields()
+		setDropWhenFull(l.cfg.DropWhenFull)
+		l.seq = l.cfg.SequenceBase
+		l.logConfig()
+		return
+	}
+	l.flushLogMsgs()
+	l.out = w
+	if w == nil && l.wtr == nil {
+		// l was initialised without log files
+		reload := l.reload
+		l.initOrStderr(l.cfg)
+		l.reload = reload
+		return
+	}
+	l.logConfig()
+}
+
+// header returns the header of the log files of l with configuration cfg: the version of l,
+// if it is set, followed by the hostname and pid of the process unless cfg.MinimalHeader is set
+func (l *logger) header(cfg *Config) string {
+	var lines []string
+	if l.version != "" {
+		lines = append(lines, "Version: "+l.version)
+	}
+	if !cfg.MinimalHeader {
+		lines = append(lines, fmt.Sprintf("Host: %s, PID: %d", hostname, pid))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// filesConfig returns the configuration of the default log files of cfg with header header
+func filesConfig(cfg *Config, header string) *files.Config {
+	return &files.Config{
+		LogDir:         cfg.RootDir,
+		LogName:        cfg.FileName,
+		MaxFileSize:    cfg.FileNumBytes,
+		MaxNumFiles:    cfg.NumFiles,
+		Header:         header,
+		JSONHeader:     cfg.Format == FormatJSON,
+		TimeFormat:     cfg.TimeFormat,
+		UTC:            cfg.UTC,
+		Compress:       cfg.Compress,
+		RotateInterval: cfg.RotateInterval,
+		MaxTotalBytes:  cfg.MaxTotalBytes,
+		FileMode:       cfg.FileMode,
+		DirMode:        cfg.DirMode,
+		FileTimeFormat: cfg.FileTimeFormat,
+		Append:         cfg.Append,
+	}
+}
+
+// routeConfig returns the configuration of the log files of the route r of cfg with header
+// header. The parameters not set by r are those of the default log files.
+func routeConfig(cfg *Config, r *Route, header string) *files.Config {
+	fcfg := filesConfig(cfg, header)
+	fcfg.LogName = cfg.FileName + "." + r.Name()
+	if r.RootDir != "" {
+		fcfg.LogDir = r.RootDir
+	}
+	if r.FileNumBytes != 0 {
+		fcfg.MaxFileSize = r.FileNumBytes
+	}
+	if r.NumFiles != 0 {
+		fcfg.MaxNumFiles = r.NumFiles
+	}
+	return fcfg
+}
+
+// openRoutes returns the log files of the routes of cfg with header header
+func openRoutes(cfg *Config, header string) ([]*files.FileSet, error) {
+	routes := make([]*files.FileSet, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		wtr, err := files.Open(routeConfig(cfg, r, header))
+		if err != nil {
+			closeRoutes(routes)
+			return nil, err
+		}
+		routes = append(routes, wtr)
+	}
+	return routes, nil
+}
+
+// setRoutes replaces the routes of l by the routes of cfg if they differ. If the log files of the
+// new routes cannot be opened the routes of l are retained.
+func (l *logger) setRoutes(cfg *Config) {
+	if equalRoutes(l.cfg.Routes, cfg.Routes) {
+		return
+	}
+	routes, err := openRoutes(cfg, l.header(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening log routes: %s\n", err)
+		cfg.Routes = l.cfg.Routes
+		return
+	}
+	l.flushLogMsgs()
+	closeRoutes(l.routes)
+	l.routes = routes
+}
+
+// isSuppressed returns true if the messages of priority from the source file, file, of the
+// function at pc are suppressed by the suppressed files or the suppression regular expressions
+// of l, or are not enabled by the debug files of l. pc is 0 if it is unknown.
+func (l *logger) isSuppressed(pc uintptr, file string, priority Priority) bool {
 	if priority < DEBUG {
 		return false
 	}
-	if l.cfg.SuppressedFiles == "" {
+	_, fname := path.Split(file)
+	for _, re := range l.suppressRegexps {
+		if re.MatchString(fname) {
+			return true
+		}
+	}
+	if l.cfg.DebugFiles != "" && !inFileList(l.cfg.DebugFiles, pc, file) {
+		return true
+	}
+	return inFileList(l.cfg.SuppressedFiles, pc, file)
+}
+
+// inFileList returns true if the comma separated list of file names, files, contains the source
+// file, file, of the function at pc. A file name in files is either the name of a file, e.g.:
+// "util", or its package qualified name, e.g.: "github.com/me/app/auth/util", which also matches
+// a package path ending in the path of the name, e.g.: "auth/util". The ".go" extensions of the
+// file names are optional.
+func inFileList(files string, pc uintptr, file string) bool {
+	if files == "" {
 		return false
 	}
-	fns := strings.Split(file, ".")
-	fn := strings.Join(fns[:len(fns)-1], ".")
-	suppress := strings.Contains(l.cfg.SuppressedFiles, fn)
-	return suppress
+	name := strings.TrimSuffix(path.Base(file), ".go")
+	qualified := ""
+	for _, f := range strings.Split(files, ",") {
+		f = strings.TrimSuffix(strings.TrimSpace(f), ".go")
+		if !strings.Contains(f, "/") {
+			if f == name {
+				return true
+			}
+			continue
+		}
+		if qualified == "" {
+			qualified = qualifiedFile(pc, file)
+		}
+		if hasPathSuffix(qualified, f) || hasPathSuffix(strings.TrimSuffix(file, ".go"), f) {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifiedFile returns the package qualified name of the source file, file, of the function at
+// pc, e.g.: "github.com/me/app/auth/util", or the path of file without extension if pc is unknown.
+func qualifiedFile(pc uintptr, file string) string {
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return strings.TrimSuffix(file, ".go")
+	}
+	return funcPackage(fn.Name()) + "/" + strings.TrimSuffix(path.Base(file), ".go")
+}
+
+// funcPackage returns the package path of the qualified function name fn, e.g.:
+// "github.com/me/app/auth" of "github.com/me/app/auth.(*Server).Login"
+func funcPackage(fn string) string {
+	i := strings.LastIndex(fn, "/") + 1
+	if j := strings.Index(fn[i:], "."); j >= 0 {
+		return fn[:i+j]
+	}
+	return fn
 }
 
+// funcName returns the name of the function at pc without its package path, e.g.:
+// "(*Server).Login" of "github.com/me/app/auth.(*Server).Login", or "" if pc is unknown.
+func funcName(pc uintptr) string {
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	return strings.TrimPrefix(name[len(funcPackage(name)):], ".")
+}
+
+// hasPathSuffix returns true if path pth is equal to suffix or ends in "/" followed by suffix
+func hasPathSuffix(pth, suffix string) bool {
+	return pth == suffix || strings.HasSuffix(pth, "/"+suffix)
+}
+
+// logConfig writes the configuration of l to all its log files, or to its output if it is set
 func (l *logger) logConfig() {
-	fmt.Fprintf(l.wtr, "%s Log configuration:\n", time.Now().Format(time.RFC3339Nano))
-	fmt.Fprintf(l.wtr, "  RootDir: %s\n", l.cfg.RootDir)
-	fmt.Fprintf(l.wtr, "  NumFiles: %d\n", l.cfg.NumFiles)
-	fmt.Fprintf(l.wtr, "  NumBytes: %d\n", l.cfg.FileNumBytes)
-	fmt.Fprintf(l.wtr, "  Priority: %s\n", l.cfg.Priority)
-	fmt.Fprintf(l.wtr, "  Suppress: %s\n", l.cfg.SuppressedFiles)
+	if l.cfg.Format == FormatJSON {
+		l.logJSONConfig()
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s Log configuration:\n", l.now())
+	if l.version != "" {
+		fmt.Fprintf(&sb, "  Version: %s\n", l.version)
+	}
+	fmt.Fprintf(&sb, "  RootDir: %s\n", l.cfg.RootDir)
+	fmt.Fprintf(&sb, "  NumFiles: %d\n", l.cfg.NumFiles)
+	fmt.Fprintf(&sb, "  NumBytes: %d\n", l.cfg.FileNumBytes)
+	fmt.Fprintf(&sb, "  Priority: %s\n", l.cfg.Priority)
+	fmt.Fprintf(&sb, "  Suppress: %s\n", l.cfg.SuppressedFiles)
+	if l.cfg.DebugFiles != "" {
+		fmt.Fprintf(&sb, "  DebugFiles: %s\n", l.cfg.DebugFiles)
+	}
+	for _, re := range l.suppressRegexps {
+		fmt.Fprintf(&sb, "  SuppressRegex: %s\n", re)
+	}
+	fmt.Fprintf(&sb, "  LineEnding: %q\n", l.cfg.LineEnding)
+	fmt.Fprintf(&sb, "  CallerStyle: %s\n", l.cfg.CallerStyle)
+	if l.cfg.FuncName {
+		fmt.Fprintf(&sb, "  FuncName: true\n")
+	}
+	if l.cfg.TimeFormat != "" && l.cfg.TimeFormat != DefaultTimeFormat {
+		fmt.Fprintf(&sb, "  TimeFormat: %s\n", l.cfg.TimeFormat)
+	}
+	if l.cfg.UTC {
+		fmt.Fprintf(&sb, "  UTC: true\n")
+	}
+	if l.cfg.Compress {
+		fmt.Fprintf(&sb, "  Compress: true\n")
+	}
+	if l.cfg.RotateInterval > 0 {
+		fmt.Fprintf(&sb, "  RotateInterval: %s\n", l.cfg.RotateInterval)
+	}
+	if l.cfg.MaxTotalBytes > 0 {
+		fmt.Fprintf(&sb, "  MaxTotalBytes: %d\n", l.cfg.MaxTotalBytes)
+	}
+	if l.cfg.FileMode != 0 {
+		fmt.Fprintf(&sb, "  FileMode: %#o\n", uint32(l.cfg.FileMode))
+	}
+	if l.cfg.DirMode != 0 {
+		fmt.Fprintf(&sb, "  DirMode: %#o\n", uint32(l.cfg.DirMode))
+	}
+	if l.cfg.FileTimeFormat != "" {
+		fmt.Fprintf(&sb, "  FileTimeFormat: %s\n", l.cfg.FileTimeFormat)
+	}
+	if l.cfg.Append {
+		fmt.Fprintf(&sb, "  Append: true\n")
+	}
+	for _, r := range l.cfg.Routes {
+		fmt.Fprintf(&sb, "  Route: %s\n", r)
+	}
+	if l.fieldsText != "" {
+		fmt.Fprintf(&sb, "  Fields:%s\n", l.fieldsText)
+	}
+	if l.cfg.Sequence {
+		fmt.Fprintf(&sb, "  Sequence: next %d\n", l.seq)
+	}
+	if l.cfg.Console {
+		fmt.Fprintf(&sb, "  Console: true\n")
+	}
+	if l.cfg.DropWhenFull {
+		fmt.Fprintf(&sb, "  DropWhenFull: true\n")
+	}
+	if l.cfg.Dedup {
+		fmt.Fprintf(&sb, "  Dedup: true\n")
+	}
+	l.writeAll(sb.String())
 }
 
-func (l *logger) logExit(file string, line int, exitCode int, msg string) {
-	_, fname := path.Split(file)
-	l.write(fmt.Sprintf("%s [EXIT %d] -%s, line %d- %s\n%s",
-		time.Now().Format(time.RFC3339Nano),
-		exitCode,
-		fname, line,
-		strings.TrimRight(msg, "\n"),
-		""))
+func (l *logger) logExit(pc uintptr, file string, line int, exitCode int, msg string,
+	fields []*field) {
+
+	msg = strings.TrimRight(msg, "\n")
+	l.callHooks(EXIT, file, line, msg)
+	l.writeEntry(EXIT, l.entry(EXIT, exitCode, pc, file, line, msg, "", fields))
 }
 
-func (l *logger) logMsg(file string, line int, priority Priority,
-	format string, a []interface{},
-	stackTrace string) {
+// logPanic writes a PANIC message with stack trace stackTrace
+func (l *logger) logPanic(pc uintptr, file string, line int, msg, stackTrace string,
+	fields []*field) {
+
+	if l.panicFormatter == nil {
+		l.logMsg(pc, file, line, PANIC, msg, nil, true, stackTrace, fields)
+		return
+	}
+	msg = strings.TrimRight(msg, "\n")
+	l.callHooks(PANIC, file, line, msg)
+	report := newCrashReport(l.caller(file), line, msg, stackTrace)
+	if l.cfg.UTC {
+		report.Timestamp = report.Timestamp.UTC()
+	}
+	report.timestamp = l.formatTime(report.Timestamp)
+	if len(fields) > 0 {
+		report.Fields = make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			report.Fields[f.key] = f.value
+		}
+	}
+	l.writeEntry(PANIC, l.panicFormatter(report))
+}
+
+// logMsg writes a message of priority with the fields of the message, fields, unless
+// priority is lower than the configured priority or the message is suppressed. The message is
+// format if verbatim is set and is formatted with the arguments a otherwise. pc is the program
+// counter of the log call, or 0 if it is unknown.
+func (l *logger) logMsg(pc uintptr, file string, line int, priority Priority,
+	format string, a []interface{}, verbatim bool,
+	stackTrace string, fields []*field) {
 
 	_, fname := path.Split(file)
-	if priority <= l.cfg.Priority && !l.isSuppressed(fname, priority) {
-		msg := fmt.Sprintf(strings.TrimRight(format, "\n"), a...)
-		l.write(fmt.Sprintf("%s [%s] -%s, line %d- %s\n%s",
-			time.Now().Format(time.RFC3339Nano),
-			priority,
-			fname, line,
-			msg,
-			strings.TrimRight(stackTrace, "\n")))
+	if priority > l.cfg.Priority {
+		return
+	}
+	if l.isSuppressed(pc, file, priority) {
+		if l.suppressed == nil {
+			l.suppressed = make(map[string]uint64)
+		}
+		l.suppressed[fname]++
+		return
 	}
+	if l.rateLimited(pc, file, line, priority) {
+		return
+	}
+	msg := strings.TrimRight(format, "\n")
+	if !verbatim {
+		msg = fmt.Sprintf(msg, a...)
+	}
+	l.callHooks(priority, file, line, msg)
+	if stackTrace == "" && l.isRepeat(priority, pc, file, line, msg, fields) {
+		return
+	}
+	if l.rotateOn != nil && l.rotateOn(Entry{
+		Priority: priority,
+		File:     file,
+		Line:     line,
+		Message:  msg,
+	}) {
+		if wtr, ok := l.writer(priority).(*files.FileSet); ok {
+			if err := wtr.Rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error rotating log files: %s\n", err)
+			}
+		}
+	}
+	l.writeEntry(priority, l.entry(priority, 0, pc, file, line, msg, stackTrace, fields))
 }
 
-func (l *logger) run() {
-	l.cfg = readConfigFile(true)
-	l.wtr = files.New(l.cfg.RootDir, l.cfg.FileName, l.cfg.FileNumBytes, l.cfg.NumFiles)
-	defer l.close()
+// refresh returns the channel of the config refresh ticker, or nil if the log config file is
+// not re-read or is watched, see watch. The ticker is restarted if the refresh interval of the
+// configuration has changed.
+func (l *logger) refresh() <-chan time.Time {
+	if !l.reload || l.reloadDisabled || !l.watchFailed {
+		if l.refreshTicker != nil {
+			l.refreshTicker.Stop()
+			l.refreshTicker = nil
+		}
+		return nil
+	}
+	interval := l.cfg.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	if l.refreshTicker == nil || interval != l.refreshInterval {
+		if l.refreshTicker != nil {
+			l.refreshTicker.Stop()
+		}
+		l.refreshTicker, l.refreshInterval = time.NewTicker(interval), interval
+	}
+	return l.refreshTicker.C
+}
+
+// revert returns the channel of the revert timer, or nil if no priority reversion is pending
+func (l *logger) revert() <-chan time.Time {
+	if l.revertTimer == nil {
+		return nil
+	}
+	return l.revertTimer.C
+}
+
+// rotate starts new log files for l and its routes. It returns the first error encountered.
+func (l *logger) rotate() error {
+	var err error
+	for _, wtr := range l.fileSets() {
+		if err1 := wtr.Rotate(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// setVersion sets the version of l and the header of its log files. The log configuration,
+// including the version, is written to the current log files if l has been initialised.
+func (l *logger) setVersion(version string) {
+	l.version = version
+	if l.cfg == nil {
+		return
+	}
+	l.flushLogMsgs()
+	for _, wtr := range l.fileSets() {
+		wtr.SetHeader(l.header(l.cfg))
+	}
+	l.logConfig()
+}
+
+// setLevelForDuration sets the priority of l to priority and schedules the reversion to the
+// current priority after d. If a reversion is already pending it is rescheduled and the
+// priority preceding the pending reversion is retained.
+func (l *logger) setLevelForDuration(priority Priority, d time.Duration) {
+	if l.revertTimer == nil {
+		l.revertTo = l.cfg.Priority
+	} else {
+		l.revertTimer.Stop()
+	}
+	l.revertTimer = time.NewTimer(d)
+	l.cfg.Priority = priority
 	l.logConfig()
+}
+
+// setBufferSize replaces the message buffer of l by a buffer of size messages. The buffer can
+// only be replaced before l has been used.
+func (l *logger) setBufferSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("Error setting log buffer size: invalid size %d", size)
+	}
+	if l.cfg != nil || len(logChan()) > 0 {
+		return errors.New("Error setting log buffer size: the logger has already been used")
+	}
+	logBuf.Store(make(chan *logMsg, size))
+	return nil
+}
+
+// setDropWhenFull sets the mode of logIF when the message buffer is full: to drop if drop is
+// true, else to block
+func setDropWhenFull(drop bool) {
+	var d int32
+	if drop {
+		d = 1
+	}
+	atomic.StoreInt32(&dropWhenFull, d)
+}
+
+// disable writes the pending messages of l, closes its log files and discards all further
+// messages until l is initialised by Init. If l has not been initialised it is configured with
+// the default configuration without reading the log config file or creating any log files.
+func (l *logger) disable() {
+	if l.cfg == nil {
+		l.cfg = DefaultConfig()
+	} else {
+		l.flushLogMsgs()
+		l.logRateSummaries()
+	}
+	atomic.StoreInt32(&disabled, 1)
+	for _, wtr := range l.fileSets() {
+		wtr.Close()
+	}
+	l.wtr, l.routes, l.reload = nil, nil, false
+}
+
+// isDisabled returns true if all messages are discarded after Disable
+func isDisabled() bool {
+	return atomic.LoadInt32(&disabled) == 1
+}
+
+// stopRevert cancels a pending priority reversion
+func (l *logger) stopRevert() {
+	if l.revertTimer != nil {
+		l.revertTimer.Stop()
+		l.revertTimer = nil
+	}
+}
 
-	refreshConfig := time.NewTicker(10 * time.Second)
+// refreshConfig re-reads the log config file and applies changed parameters if the file has
+// changed since it was last read, unless l was initialised by Init or config reloading is
+// disabled.
+func (l *logger) refreshConfig() {
+	if !l.reload || l.reloadDisabled {
+		return
+	}
+	state := statConfigFile()
+	if !l.cfgFile.changed(state) {
+		return
+	}
+	l.cfgFile = state
+	newCfg := readConfigFile(false)
+	if l.revertTimer != nil {
+		// keep the temporary priority and revert to the priority of the config file
+		l.revertTo, newCfg.Priority = newCfg.Priority, l.cfg.Priority
+	}
+	if !l.cfg.Equal(newCfg) {
+		l.flushLogMsgs()
+		if l.wtr != nil {
+			l.keepOpenParams(newCfg)
+			l.setRoutes(newCfg)
+			l.reconfigure(newCfg)
+		}
+		l.cfg = newCfg
+		l.setFields()
+		setDropWhenFull(l.cfg.DropWhenFull)
+		l.logConfig()
+	}
+}
 
+// keepOpenParams resets the parameters of cfg that only apply when the log files of l are opened
+// to those of l, with a warning on os.Stderr if they have changed.
+func (l *logger) keepOpenParams(cfg *Config) {
+	if cfg.DirMode != l.cfg.DirMode {
+		fmt.Fprintf(os.Stderr, "Warning: DirMode %#o applies after a restart, keeping %#o\n",
+			cfg.DirMode, l.cfg.DirMode)
+		cfg.DirMode = l.cfg.DirMode
+	}
+	if cfg.FileTimeFormat != l.cfg.FileTimeFormat {
+		if err := files.CheckFileTimeFormat(cfg.FileTimeFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s, keeping %q\n", err, l.cfg.FileTimeFormat)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: FileTimeFormat %q applies after a restart, keeping %q\n",
+				cfg.FileTimeFormat, l.cfg.FileTimeFormat)
+		}
+		cfg.FileTimeFormat = l.cfg.FileTimeFormat
+	}
+	if cfg.Append != l.cfg.Append {
+		fmt.Fprintf(os.Stderr, "Warning: Append %t applies after a restart, keeping %t\n",
+			cfg.Append, l.cfg.Append)
+		cfg.Append = l.cfg.Append
+	}
+}
+
+// reconfigure applies the parameters of cfg that can be changed while the log files are open to
+// the log files of l and its routes. The routes of l must be those of cfg.
+func (l *logger) reconfigure(cfg *Config) {
+	header := l.header(cfg)
+	fcfgs := []*files.Config{filesConfig(cfg, header)}
+	for _, r := range cfg.Routes {
+		fcfgs = append(fcfgs, routeConfig(cfg, r, header))
+	}
+	for i, wtr := range l.fileSets() {
+		if err := wtr.Reconfigure(fcfgs[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring log files: %s\n", err)
+		}
+	}
+}
+
+func (l *logger) run() {
 	for {
 		select {
-		case <-closeChan:
+		case replyTo := <-closeChan:
+			replyTo <- l.close()
+			closedConfig = l.cfg
+			if closedConfig == nil {
+				closedConfig = DefaultConfig()
+			}
+			close(closedChan)
 			return
+		case msg := <-bufferSizeChan:
+			msg.replyTo <- l.setBufferSize(msg.size)
+		case msg := <-enabledChan:
+			l.autoInit()
+			msg.replyTo <- msg.priority <= l.cfg.Priority
 		case msg := <-exitChan:
-			l.logExit(msg.file, msg.line, msg.exitCode, msg.msg)
+			l.autoInit()
+			// messages logged before the exit precede it in the log
+			l.flushLogMsgs()
+			l.logExit(msg.pc, msg.file, msg.line, msg.exitCode, msg.msg, msg.fields)
 			l.close()
 			os.Exit(msg.exitCode)
-		case msg := <-logChan:
-			l.logMsg(msg.file, msg.line, msg.priority, msg.format, msg.a, "")
+		case replyTo := <-flushChan:
+			if l.cfg != nil {
+				l.flushLogMsgs()
+				for _, wtr := range l.fileSets() {
+					wtr.Flush()
+				}
+			}
+			replyTo <- true
+		case msg := <-hookChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			if msg.f == nil {
+				l.removeHook(msg.id)
+			} else {
+				msg.id = l.addHook(msg.f)
+			}
+			msg.replyTo <- msg.id
+		case msg := <-initChan:
+			msg.replyTo <- l.init(msg.cfg)
+		case msg := <-logChan():
+			l.autoInit()
+			l.logMsg(msg.pc, msg.file, msg.line, msg.priority, msg.format, msg.a, msg.verbatim, "",
+				msg.fields)
 		case msg := <-panicChan:
-			l.logMsg(msg.file, msg.line, PANIC, msg.msg, nil, msg.stacktrace)
+			l.autoInit()
+			// messages logged before the panic precede it in the log
+			l.flushLogMsgs()
+			l.logPanic(msg.pc, msg.file, msg.line, msg.msg, msg.stacktrace, msg.fields)
 			l.close()
 			os.Exit(1)
-		case <-refreshConfig.C:
-			newCfg := readConfigFile(false)
-			if !l.cfg.Equal(newCfg) {
-				l.cfg = newCfg
-				l.wtr.SetConfig(l.cfg.NumFiles, l.cfg.FileNumBytes)
-				l.logConfig()
-			}
+		case msg := <-outputChan:
+			l.setOutput(msg.w)
+			msg.replyTo <- true
+		case msg := <-panicFmtChan:
+			l.panicFormatter = msg.f
+			msg.replyTo <- true
+		case msg := <-versionChan:
+			l.setVersion(msg.version)
+			msg.replyTo <- true
+		case msg := <-rateLimitChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.setRateLimit(msg.maxPerSecond)
+			msg.replyTo <- true
+		case <-l.rateTick():
+			l.logRateSummaries()
+		case <-l.repeatFlush():
+			l.repeatTimer = nil
+			l.flushRepeats()
+		case replyTo := <-rotateChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			replyTo <- l.rotate()
+		case msg := <-rotateOnChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.rotateOn = msg.predicate
+			msg.replyTo <- true
+		case ev := <-l.watch():
+			l.configEvent(ev)
+		case err := <-l.watchErrors():
+			l.watchError(err)
+		case <-l.refresh():
+			l.refreshConfig()
+		case msg := <-levelChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.setLevelForDuration(msg.priority, msg.duration)
+			msg.replyTo <- true
+		case <-l.revert():
+			l.revertTimer = nil
+			l.flushLogMsgs()
+			l.cfg.Priority = l.revertTo
+			l.logConfig()
+		case replyTo := <-disableChan:
+			l.reloadDisabled = true
+			replyTo <- true
+		case replyTo := <-disableLogChan:
+			l.disable()
+			replyTo <- true
 		case cm := <-setConfigChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.stopRevert()
 			l.cfg.NumFiles = cm.maxFiles
 			l.cfg.FileNumBytes = cm.maxBytes
 			l.cfg.Priority = cm.priority
-			l.flushLogMsgs()
-			l.wtr.SetConfig(cm.maxFiles, cm.maxBytes)
+			if l.wtr != nil {
+				if err := l.wtr.SetConfig(cm.maxFiles, cm.maxBytes); err != nil {
+					fmt.Fprintf(os.Stderr, "Error configuring log files: %s\n", err)
+				}
+			}
 			l.logConfig()
+			cm.replyTo <- true
 		case replyTo := <-getConfigChan:
+			l.autoInit()
 			replyTo <- l.cfg.Clone()
-		case s := <-suppressChan:
-			l.cfg.SuppressedFiles = s
+		case replyTo := <-fileStatsChan:
+			l.autoInit()
+			replyTo <- l.fileStats()
+		case msg := <-statsChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			stats := make(map[string]uint64, len(l.suppressed))
+			for fname, n := range l.suppressed {
+				stats[fname] = n
+			}
+			if msg.reset {
+				l.suppressed = nil
+			}
+			msg.replyTo <- stats
+		case msg := <-suppressRxChan:
+			l.autoInit()
 			l.flushLogMsgs()
+			l.suppressRegexps = msg.regexps
 			l.logConfig()
+			msg.replyTo <- true
+		case msg := <-debugFilesChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.cfg.DebugFiles = msg.files
+			l.logConfig()
+			msg.replyTo <- true
+		case msg := <-suppressChan:
+			l.autoInit()
+			l.flushLogMsgs()
+			l.cfg.SuppressedFiles = msg.files
+			l.logConfig()
+			msg.replyTo <- true
+		}
+	}
+}
+
+// writeAll writes msg to the default log files of l and to the log files of all its routes
+func (l *logger) writeAll(msg string) {
+	if isDisabled() {
+		return
+	}
+	if l.out != nil {
+		l.writeTo(l.out, msg)
+		return
+	}
+	l.writeTo(l.wtr, msg)
+	for _, wtr := range l.routes {
+		l.writeTo(wtr, msg)
+	}
+}
+
+// writeEntry writes the log entry, msg, of a message of priority to the writer of priority and,
+// if console output is configured, to the console.
+func (l *logger) writeEntry(priority Priority, msg string) {
+	if isDisabled() {
+		return
+	}
+	l.writeTo(l.writer(priority), msg)
+	if l.cfg.Console {
+		wtr := console(priority)
+		if l.cfg.ForceColor || l.cfg.Color && isTerminal(wtr) {
+			msg = colorize(priority, msg)
+		}
+		l.writeTo(wtr, msg)
+	}
+}
+
+// ANSI colors of the console messages of each priority
+var colors = map[Priority]string{
+	EXIT:    "\x1b[31m", // red
+	PANIC:   "\x1b[31m", // red
+	WARNING: "\x1b[33m", // yellow
+	DEBUG:   "\x1b[2m",  // dim
+	TRACE:   "\x1b[2m",  // dim
+}
+
+const colorReset = "\x1b[0m"
+
+// colorize returns msg in the color of priority. The final newline of msg follows the color reset.
+func colorize(priority Priority, msg string) string {
+	color, exist := colors[priority]
+	if !exist {
+		return msg
+	}
+	text := strings.TrimSuffix(msg, "\n")
+	return color + text + colorReset + msg[len(text):]
+}
+
+// isTerminal returns true if w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// console returns the console stream of messages of priority
+func console(priority Priority) io.Writer {
+	if priority <= WARNING {
+		return consoleErr
+	}
+	return consoleOut
+}
+
+// writer returns the output of l if it is set, else the log files of the first route of
+// priority, or the default log files of l
+func (l *logger) writer(priority Priority) io.Writer {
+	if l.out != nil {
+		return l.out
+	}
+	for i, r := range l.cfg.Routes {
+		if r.Covers(priority) {
+			return l.routes[i]
 		}
 	}
+	return l.wtr
 }
 
-func (l *logger) write(msg string) {
-	if _, err := l.wtr.Write(([]byte)(msg)); err != nil {
-		panic(err)
+// writeTo writes msg to wtr, terminating every line of msg with the configured line ending.
+func (l *logger) writeTo(wtr io.Writer, msg string) {
+	if l.cfg.LineEnding != "" && l.cfg.LineEnding != "\n" {
+		// A message that already has CRLF line endings must not get a doubled CR
+		msg = strings.Replace(msg, "\r\n", "\n", -1)
+		msg = strings.Replace(msg, "\n", l.cfg.LineEnding, -1)
 	}
+	_, err := wtr.Write(([]byte)(msg))
+	if err != nil && l.writeErr == nil {
+		fmt.Fprintf(os.Stderr, "Error writing log: %s\n", err)
+	}
+	l.writeErr = err
 }
 
 /***** Utility ******/
 
-func getFileLine() (file string, line int) {
-	_, file, line, _ = runtime.Caller(3)
-	return file, line
+// getHostname returns the hostname of the machine, or "unknown" if it cannot be determined
+func getHostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// getCaller returns the program counter, source file path and line number of the call to a
+// logger interface routine, or of the caller skip frames above it. getCaller must be called by
+// the function called by the logger interface routine.
+func getCaller(skip int) (pc uintptr, file string, line int) {
+	pc, file, line, _ = runtime.Caller(3 + skip)
+	return pc, file, line
 }
@@ -0,0 +1,900 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccmack/goutil/log/files"
+)
+
+// newTestLogger returns a logger writing to a new temporary directory
+func newTestLogger(t *testing.T, name string) (*logger, string) {
+	dir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = name
+	l := &logger{
+		cfg: cfg,
+		wtr: files.New(dir, name, cfg.FileNumBytes, cfg.NumFiles),
+	}
+	return l, dir
+}
+
+// readLog returns the contents of all log files of name in dir
+func readLog(t *testing.T, dir, name string) string {
+	var sb strings.Builder
+	for _, fname := range files.ListLogFiles(dir, name) {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(data)
+	}
+	return sb.String()
+}
+
+func TestLineEndingCRLF(t *testing.T) {
+	l, dir := newTestLogger(t, "crlf")
+	defer os.RemoveAll(dir)
+	l.cfg.LineEnding = "\r\n"
+
+	l.logConfig()
+	l.logMsg(0, "/a/b/main.go", 10, INFO, "line one", nil, false, "", nil)
+	l.logMsg(0, "/a/b/main.go", 10, INFO, "crlf one\r\ncrlf two\r\n", nil, false, "", nil)
+	l.logMsg(0, "/a/b/main.go", 11, PANIC, "panic", nil, false, "goroutine 1\nmain.main()\n", nil)
+	l.logExit(0, "/a/b/main.go", 12, 3, "exit", nil)
+	l.wtr.Close()
+
+	log := readLog(t, dir, "crlf")
+	// Skip the file set header, which is not written by the logger
+	i := strings.Index(log, "Log configuration:")
+	if i < 0 {
+		t.Fatalf("missing log configuration in:\n%s", log)
+	}
+	log = log[strings.LastIndex(log[:i], "\n")+1:]
+	if !strings.HasSuffix(log, "\r\n") {
+		t.Errorf("log does not end with CRLF: %q", log)
+	}
+	if strings.Contains(log, "\r\r") {
+		t.Errorf("doubled CR in log: %q", log)
+	}
+	if !strings.Contains(log, "crlf one\r\ncrlf two\r\n") {
+		t.Errorf("missing CRLF message in log: %q", log)
+	}
+	for i := strings.Index(log, "\n"); i >= 0; i = strings.Index(log, "\n") {
+		if i == 0 || log[i-1] != '\r' {
+			t.Fatalf("bare LF in log: %q", log)
+		}
+		log = log[i+1:]
+	}
+}
+
+// inTempDir runs f with a new temporary working directory containing a log.config file
+// with contents config
+func inTempDir(t *testing.T, config string, f func()) {
+	dir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, logConfigFileSuffix), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	f()
+}
+
+func TestConfigFileEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfgFile := filepath.Join(dir, "app.json")
+	if err := ioutil.WriteFile(cfgFile, []byte(`{"Priority": "DEBUG"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(ConfigFileEnv, cfgFile)
+	defer os.Unsetenv(ConfigFileEnv)
+
+	// The log.config file in $PWD is ignored
+	inTempDir(t, `{"Priority": "WARNING"}`, func() {
+		if cfg := readConfigFile(true); cfg.Priority != DEBUG {
+			t.Errorf("priority %s", cfg.Priority)
+		}
+		if state := statConfigFile(); state.name != cfgFile {
+			t.Errorf("state of %q", state.name)
+		}
+		os.Setenv(ConfigFileEnv, filepath.Join(dir, "missing.json"))
+		if cfg := readConfigFile(true); !cfg.Equal(DefaultConfig()) {
+			t.Errorf("config %s for missing config file", cfg)
+		}
+	})
+}
+
+func TestDisabledConfigReload(t *testing.T) {
+	l, dir := newTestLogger(t, "reload")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+
+	inTempDir(t, fmt.Sprintf(`{"RootDir": %q, "Priority": "DEBUG"}`, dir), func() {
+		l.reloadDisabled = true
+		l.refreshConfig()
+		if l.cfg.Priority != INFO {
+			t.Errorf("priority %s after disabled reload", l.cfg.Priority)
+		}
+
+		l.reloadDisabled = false
+		l.refreshConfig()
+		if l.cfg.Priority != DEBUG {
+			t.Errorf("priority %s after reload", l.cfg.Priority)
+		}
+	})
+}
+
+func TestConfigFileChange(t *testing.T) {
+	l, dir := newTestLogger(t, "change")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+
+	inTempDir(t, fmt.Sprintf(`{"RootDir": %q, "Priority": "DEBUG"}`, dir), func() {
+		l.refreshConfig()
+		if l.cfg.Priority != DEBUG {
+			t.Fatalf("priority %s after reload", l.cfg.Priority)
+		}
+		// An unchanged file is not re-read
+		l.cfg.Priority = WARNING
+		l.refreshConfig()
+		if l.cfg.Priority != WARNING {
+			t.Errorf("priority %s after reload of unchanged file", l.cfg.Priority)
+		}
+
+		// Atomic replacement
+		cfg := fmt.Sprintf(`{"RootDir": %q, "Priority": "INFO"}`, dir)
+		if err := ioutil.WriteFile("new", []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename("new", logConfigFileSuffix); err != nil {
+			t.Fatal(err)
+		}
+		l.refreshConfig()
+		if l.cfg.Priority != INFO {
+			t.Errorf("priority %s after replacing the config file", l.cfg.Priority)
+		}
+
+		// Creation after removal
+		if err := os.Remove(logConfigFileSuffix); err != nil {
+			t.Fatal(err)
+		}
+		l.refreshConfig()
+		cfg = fmt.Sprintf(`{"RootDir": %q, "Priority": "DEBUG"}`, dir)
+		if err := ioutil.WriteFile(logConfigFileSuffix, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		l.refreshConfig()
+		if l.cfg.Priority != DEBUG {
+			t.Errorf("priority %s after creating the config file", l.cfg.Priority)
+		}
+	})
+}
+
+func TestConfigFileWatch(t *testing.T) {
+	l, dir := newTestLogger(t, "watch")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+
+	inTempDir(t, "", func() {
+		if err := os.Remove(logConfigFileSuffix); err != nil {
+			t.Fatal(err)
+		}
+		l.cfgFile = statConfigFile()
+		events := l.watch()
+		if events == nil {
+			t.Skip("config file not watched")
+		}
+		defer l.stopWatch()
+		// await applies the events of the config file until the priority is p
+		await := func(p Priority, change string) {
+			timeout := time.After(5 * time.Second)
+			for l.cfg.Priority != p {
+				select {
+				case ev := <-events:
+					l.configEvent(ev)
+				case err := <-l.watchErrors():
+					t.Fatal(err)
+				case <-timeout:
+					t.Fatalf("priority %s after %s", l.cfg.Priority, change)
+				}
+			}
+		}
+
+		// Creation after startup
+		cfg := fmt.Sprintf(`{"RootDir": %q, "Priority": "DEBUG"}`, dir)
+		if err := ioutil.WriteFile(logConfigFileSuffix, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		await(DEBUG, "creating the config file")
+
+		// Atomic replacement
+		cfg = fmt.Sprintf(`{"RootDir": %q, "Priority": "WARNING"}`, dir)
+		if err := ioutil.WriteFile("new", []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename("new", logConfigFileSuffix); err != nil {
+			t.Fatal(err)
+		}
+		await(WARNING, "replacing the config file")
+
+		l.reloadDisabled = true
+		if l.watch() != nil || l.watcher != nil {
+			t.Error("config file watched after disabling config reload")
+		}
+	})
+}
+
+func TestConfigFileCompress(t *testing.T) {
+	l, dir := newTestLogger(t, "compress")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+	// compressed returns the number of compressed log files after waiting for the compression of
+	// n files
+	compressed := func(n int) int {
+		var gz []string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if gz, _ = filepath.Glob(filepath.Join(dir, "compress*.gz")); len(gz) >= n {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return len(gz)
+	}
+
+	inTempDir(t, fmt.Sprintf(`{"RootDir": %q, "Compress": true}`, dir), func() {
+		l.refreshConfig()
+		if !l.cfg.Compress {
+			t.Fatal("Compress not set")
+		}
+		if err := l.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		if n := compressed(1); n != 1 {
+			t.Fatalf("%d compressed log files", n)
+		}
+
+		cfg := fmt.Sprintf(`{"RootDir": %q, "Compress": false}`, dir)
+		if err := ioutil.WriteFile(logConfigFileSuffix, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		l.refreshConfig()
+		if l.cfg.Compress {
+			t.Fatal("Compress not reset")
+		}
+		// the log configuration is written to the current file, which is rotated uncompressed
+		if err := l.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		// Close waits for pending compressions
+		if err := l.wtr.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := compressed(1); n != 1 {
+			t.Errorf("%d compressed log files after resetting Compress", n)
+		}
+		if logFiles := files.ListLogFiles(dir, "compress"); len(logFiles) != 2 {
+			t.Errorf("log files %v", logFiles)
+		}
+	})
+}
+
+func TestConfigFileModes(t *testing.T) {
+	l, dir := newTestLogger(t, "modes")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+
+	inTempDir(t, fmt.Sprintf(`{"RootDir": %q, "FileMode": "0600", "DirMode": "0700",
+		"FileTimeFormat": "20060102-150405", "Append": true}`, dir), func() {
+		l.refreshConfig()
+		// the parameters that apply when the log files are opened are not reported as applied
+		if l.cfg.FileMode != 0600 || l.cfg.DirMode != 0 {
+			t.Errorf("FileMode %#o, DirMode %#o", l.cfg.FileMode, l.cfg.DirMode)
+		}
+		if l.cfg.FileTimeFormat != "" || l.cfg.Append {
+			t.Errorf("FileTimeFormat %q, Append %t", l.cfg.FileTimeFormat, l.cfg.Append)
+		}
+		if err := l.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		logFiles := files.ListLogFiles(dir, "modes")
+		fi, err := os.Stat(logFiles[len(logFiles)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm()&^0600 != 0 {
+			t.Errorf("mode %v of the new log file", fi.Mode())
+		}
+	})
+}
+
+func TestConfigFileTimeFormat(t *testing.T) {
+	l, dir := newTestLogger(t, "timefmt")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	l.reload = true
+
+	const layout = "2006-01-02 15:04:05.000"
+	inTempDir(t, fmt.Sprintf(`{"RootDir": %q, "TimeFormat": %q, "UTC": true}`, dir, layout), func() {
+		l.refreshConfig()
+		if err := l.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		logFiles := files.ListLogFiles(dir, "timefmt")
+		buf, err := ioutil.ReadFile(logFiles[len(logFiles)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the file set header of the new log file has the time layout of the messages
+		line := strings.SplitN(string(buf), "\n", 2)[0]
+		if len(line) < len(layout) {
+			t.Fatalf("header %q", line)
+		}
+		if _, err := time.Parse(layout, line[len(line)-len(layout):]); err != nil {
+			t.Errorf("header %q: %s", line, err)
+		}
+	})
+}
+
+func TestRefreshInterval(t *testing.T) {
+	l, dir := newTestLogger(t, "refresh")
+	defer os.RemoveAll(dir)
+	defer l.wtr.Close()
+	if l.refresh() != nil {
+		t.Error("refresh ticker without config reload")
+	}
+	l.reload = true
+	if l.refresh() != nil {
+		t.Error("refresh ticker while the config file is watched")
+	}
+	l.watchFailed = true
+	l.cfg.RefreshInterval = time.Hour
+	l.refresh()
+	l.cfg.RefreshInterval = 10 * time.Millisecond
+	select {
+	case <-l.refresh():
+	case <-time.After(time.Second):
+		t.Fatal("refresh ticker not reset")
+	}
+	l.reloadDisabled = true
+	if l.refresh() != nil || l.refreshTicker != nil {
+		t.Error("refresh ticker after disabling config reload")
+	}
+}
+
+// errWriter fails every write
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+func TestWriteError(t *testing.T) {
+	l := &logger{cfg: DefaultConfig(), out: errWriter{}}
+	l.logMsg(0, "/a/main.go", 1, INFO, "lost", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, INFO, "lost", nil, false, "", nil)
+	if l.writeErr == nil {
+		t.Error("write error not recorded")
+	}
+	var sb strings.Builder
+	l.out = &sb
+	l.logMsg(0, "/a/main.go", 3, INFO, "written", nil, false, "", nil)
+	if l.writeErr != nil || !strings.Contains(sb.String(), "- written\n") {
+		t.Errorf("write error %v, output %q", l.writeErr, sb.String())
+	}
+}
+
+func TestConsole(t *testing.T) {
+	var stderr, stdout strings.Builder
+	consoleErr, consoleOut = &stderr, &stdout
+	defer func() { consoleErr, consoleOut = os.Stderr, os.Stdout }()
+	l, dir := newTestLogger(t, "console")
+	defer os.RemoveAll(dir)
+	l.cfg.Console = true
+	l.cfg.Priority = DEBUG
+
+	l.logMsg(0, "/a/main.go", 1, WARNING, "warning", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, INFO, "info", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 3, DEBUG, "debug", nil, false, "", nil)
+	l.logExit(0, "/a/main.go", 4, 2, "exit", nil)
+	l.wtr.Close()
+
+	log := readLog(t, dir, "console")
+	for _, line := range strings.SplitAfter(stderr.String()+stdout.String(), "\n") {
+		if line != "" && !strings.Contains(log, line) {
+			t.Errorf("console line %q not in log:\n%s", line, log)
+		}
+	}
+	if e := stderr.String(); !strings.Contains(e, "- warning\n") || !strings.Contains(e, "- exit\n") ||
+		strings.Contains(e, "info") || strings.Contains(e, "debug") {
+		t.Errorf("stderr:\n%s", e)
+	}
+	if o := stdout.String(); !strings.Contains(o, "- info\n") || !strings.Contains(o, "- debug\n") ||
+		strings.Contains(o, "warning") || strings.Contains(o, "exit") {
+		t.Errorf("stdout:\n%s", o)
+	}
+}
+
+func TestColor(t *testing.T) {
+	var stderr, stdout strings.Builder
+	consoleErr, consoleOut = &stderr, &stdout
+	defer func() { consoleErr, consoleOut = os.Stderr, os.Stdout }()
+	l, dir := newTestLogger(t, "color")
+	defer os.RemoveAll(dir)
+	l.cfg.Console = true
+
+	// A strings.Builder is not a terminal
+	l.cfg.Color = true
+	l.logMsg(0, "/a/main.go", 1, WARNING, "plain", nil, false, "", nil)
+	l.cfg.ForceColor = true
+	l.logMsg(0, "/a/main.go", 2, WARNING, "yellow", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 3, INFO, "default", nil, false, "", nil)
+	l.wtr.Close()
+
+	lines := strings.SplitAfter(stderr.String(), "\n")
+	if len(lines) != 3 || strings.Contains(lines[0], "\x1b") ||
+		!strings.HasPrefix(lines[1], colors[WARNING]) || !strings.HasSuffix(lines[1], "- yellow"+colorReset+"\n") {
+		t.Errorf("stderr: %q", lines)
+	}
+	if o := stdout.String(); strings.Contains(o, "\x1b") {
+		t.Errorf("stdout: %q", o)
+	}
+	if log := readLog(t, dir, "color"); strings.Contains(log, "\x1b") {
+		t.Errorf("color in log file: %q", log)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	l, dir := newTestLogger(t, "rate")
+	defer os.RemoveAll(dir)
+	l.setRateLimit(5)
+	const n = 100
+	for i := 0; i < n; i++ {
+		l.logMsg(0, "/a/main.go", 14, WARNING, "hot %d", []interface{}{i}, false, "", nil)
+		l.logMsg(0, "/a/main.go", 15, INFO, "cold %d", []interface{}{i}, false, "", nil)
+	}
+	l.logRateSummaries()
+	l.wtr.Close()
+
+	log := readLog(t, dir, "rate")
+	for _, site := range []string{"- hot ", "- cold "} {
+		if written := strings.Count(log, site); written < 5 || written > 6 {
+			t.Errorf("%d %q messages written", written, site)
+		}
+	}
+	hot := strings.Count(log, "- hot ")
+	exp := fmt.Sprintf(" [WARNING] -main.go, line 14- %d messages suppressed from main.go:14\n", n-hot)
+	if !strings.Contains(log, exp) {
+		t.Errorf("%q missing from log:\n%s", exp, log)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	l, dir := newTestLogger(t, "dedup")
+	defer os.RemoveAll(dir)
+	l.cfg.Dedup = true
+	for _, msg := range []string{"a", "a", "a", "a", "b", "a", "a"} {
+		l.logMsg(0, "/a/main.go", 1, INFO, msg, nil, false, "", nil)
+	}
+	l.logMsg(0, "/a/main.go", 2, INFO, "a", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, INFO, "a", nil, false, "", nil)
+	if l.repeatFlush() == nil {
+		t.Error("no flush timer for held back repeats")
+	}
+	l.flushLogMsgs()
+	l.wtr.Close()
+
+	var msgs []string
+	for _, line := range strings.Split(readLog(t, dir, "dedup"), "\n") {
+		if i := strings.Index(line, "main.go, line "); i >= 0 {
+			msgs = append(msgs, line[i:])
+		}
+	}
+	exp := []string{
+		"main.go, line 1- a",
+		"main.go, line 1- previous message repeated 3 times",
+		"main.go, line 1- b",
+		"main.go, line 1- a",
+		"main.go, line 1- previous message repeated once",
+		"main.go, line 2- a",
+		"main.go, line 2- previous message repeated once",
+	}
+	if strings.Join(msgs, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("messages:\n%s\nexpected:\n%s", strings.Join(msgs, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	l, dir := newTestLogger(t, "hooks")
+	defer os.RemoveAll(dir)
+	var calls []string
+	id := l.addHook(func(p Priority, file string, line int, msg string) {
+		calls = append(calls, fmt.Sprintf("%s %s:%d %s", p, file, line, msg))
+	})
+	l.addHook(func(p Priority, file string, line int, msg string) {
+		if log := readLog(t, dir, "hooks"); strings.Contains(log, msg+"\n") {
+			t.Errorf("%q written before the hook was called", msg)
+		}
+	})
+	l.logMsg(0, "/a/main.go", 1, INFO, "info %d", []interface{}{1}, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, DEBUG, "discarded", nil, false, "", nil)
+	l.logPanic(0, "/a/main.go", 3, "panic\n", "goroutine 1\n", nil)
+	l.panicFormatter = JSONPanicFormatter
+	l.logPanic(0, "/a/main.go", 4, "json panic", "goroutine 1\n", nil)
+	l.logExit(0, "/a/main.go", 5, 2, "exit", nil)
+	l.removeHook(id)
+	l.logMsg(0, "/a/main.go", 6, INFO, "after removal", nil, false, "", nil)
+	l.wtr.Close()
+
+	exp := []string{
+		"INFO /a/main.go:1 info 1",
+		"PANIC /a/main.go:3 panic",
+		"PANIC /a/main.go:4 json panic",
+		"EXIT /a/main.go:5 exit",
+	}
+	if strings.Join(calls, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("hook calls:\n%s", strings.Join(calls, "\n"))
+	}
+}
+
+func TestIsSuppressed(t *testing.T) {
+	l := &logger{cfg: DefaultConfig()}
+	l.cfg.SuppressedFiles = "oauth, handler.go"
+	l.suppressRegexps = []*regexp.Regexp{regexp.MustCompile(`^gen_.*\.go$`)}
+	for fname, exp := range map[string]bool{
+		"oauth.go":     true,
+		"auth.go":      false,
+		"handler.go":   true,
+		"handlers.go":  false,
+		"gen_types.go": true,
+		"types_gen.go": false,
+	} {
+		if got := l.isSuppressed(0, "/src/app/"+fname, DEBUG); got != exp {
+			t.Errorf("%s: suppressed %t", fname, got)
+		}
+		if l.isSuppressed(0, "/src/app/"+fname, INFO) {
+			t.Errorf("%s: INFO suppressed", fname)
+		}
+	}
+}
+
+func TestDebugFiles(t *testing.T) {
+	l := &logger{cfg: DefaultConfig()}
+	l.cfg.DebugFiles = "parser, lexer.go"
+	l.cfg.SuppressedFiles = "lexer"
+	for fname, exp := range map[string]bool{
+		"parser.go": false,
+		"lexer.go":  true,
+		"main.go":   true,
+	} {
+		if got := l.isSuppressed(0, "/src/app/"+fname, DEBUG); got != exp {
+			t.Errorf("%s: suppressed %t", fname, got)
+		}
+		if l.isSuppressed(0, "/src/app/"+fname, WARNING) {
+			t.Errorf("%s: WARNING suppressed", fname)
+		}
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	for fn, exp := range map[string]string{
+		"github.com/me/app/auth.(*Server).Login": "github.com/me/app/auth",
+		"github.com/me/app/auth.Login.func1":     "github.com/me/app/auth",
+		"main.main":                              "main",
+	} {
+		if pkg := funcPackage(fn); pkg != exp {
+			t.Errorf("%s: package %s", fn, pkg)
+		}
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	pc, file, _, _ := runtime.Caller(0)
+	if fn := funcName(pc); fn != "TestFuncName" {
+		t.Errorf("function name %q", fn)
+	}
+	if fn := funcName(0); fn != "" {
+		t.Errorf("function name %q of unknown pc", fn)
+	}
+
+	l, dir := newTestLogger(t, "funcname")
+	defer os.RemoveAll(dir)
+	l.logMsg(pc, file, 1, INFO, "terse", nil, false, "", nil)
+	l.cfg.FuncName = true
+	l.logMsg(pc, file, 2, INFO, "tagged", nil, false, "", nil)
+	l.logMsg(0, file, 3, INFO, "unknown", nil, false, "", nil)
+	l.cfg.Format = FormatJSON
+	l.logMsg(pc, file, 4, INFO, "json", nil, false, "", nil)
+	l.wtr.Close()
+
+	log := readLog(t, dir, "funcname")
+	for _, exp := range []string{
+		"-log_test.go, line 1- terse\n",
+		"-log_test.go/TestFuncName, line 2- tagged\n",
+		"-log_test.go, line 3- unknown\n",
+		`"file":"log_test.go","func":"TestFuncName","line":4,`,
+	} {
+		if !strings.Contains(log, exp) {
+			t.Errorf("%q missing from log:\n%s", exp, log)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	for tf, exp := range map[string]string{
+		"":                        DefaultTimeFormat,
+		"2006-01-02 15:04:05.000": "2006-01-02 15:04:05.000",
+		time.Kitchen:              time.Kitchen,
+		"garbage":                 DefaultTimeFormat,
+	} {
+		if tf1 := checkTimeFormat(tf); tf1 != exp {
+			t.Errorf("time format %q: %q", tf, tf1)
+		}
+	}
+
+	l, dir := newTestLogger(t, "timefmt")
+	defer os.RemoveAll(dir)
+	l.cfg.TimeFormat = "2006-01-02 15:04:05.000 MST"
+	l.cfg.UTC = true
+	l.logMsg(0, "/a/main.go", 1, INFO, "utc", nil, false, "", nil)
+	l.wtr.Close()
+
+	rx := regexp.MustCompile(`(?m)^\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3} UTC \[INFO\] -main\.go, line 1- utc$`)
+	if log := readLog(t, dir, "timefmt"); !rx.MatchString(log) {
+		t.Errorf("no UTC timestamp in log:\n%s", log)
+	}
+}
+
+func TestCallerStyle(t *testing.T) {
+	const file = "/home/me/go/src/github.com/me/app/log/handler.go"
+	tests := []struct {
+		style, caller string
+	}{
+		{"", "handler.go"},
+		{CallerBase, "handler.go"},
+		{CallerPackage, "log/handler.go"},
+		{CallerFull, file},
+	}
+	for _, test := range tests {
+		l, dir := newTestLogger(t, "caller")
+		l.cfg.CallerStyle = test.style
+		l.logMsg(0, file, 14, INFO, "message", nil, false, "", nil)
+		l.logExit(0, file, 15, 2, "exit", nil)
+		l.wtr.Close()
+		log := readLog(t, dir, "caller")
+		os.RemoveAll(dir)
+		for _, exp := range []string{
+			" [INFO] -" + test.caller + ", line 14- message\n",
+			" [EXIT 2] -" + test.caller + ", line 15- exit\n",
+		} {
+			if !strings.Contains(log, exp) {
+				t.Errorf("style %q: %q missing from log:\n%s", test.style, exp, log)
+			}
+		}
+	}
+}
+
+func TestJSONPanicFormatter(t *testing.T) {
+	l, dir := newTestLogger(t, "panic")
+	defer os.RemoveAll(dir)
+	l.panicFormatter = JSONPanicFormatter
+	l.logPanic(0, "/a/b/main.go", 21, "out of cheese\n", getPanicStackTrace(), nil)
+	l.wtr.Close()
+
+	var report map[string]interface{}
+	for _, line := range strings.Split(readLog(t, dir, "panic"), "\n") {
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &report); err != nil {
+				t.Fatalf("%s: %s", err, line)
+			}
+		}
+	}
+	if report == nil {
+		t.Fatal("no crash report in log")
+	}
+	for field, exp := range map[string]interface{}{
+		"message": "out of cheese",
+		"file":    "main.go",
+		"line":    21.0,
+	} {
+		if report[field] != exp {
+			t.Errorf("%s = %v, expected %v", field, report[field], exp)
+		}
+	}
+	if _, ok := report["timestamp"].(string); !ok {
+		t.Errorf("missing timestamp: %v", report)
+	}
+
+	// the timestamp has the time format of the other entries
+	l, dir1 := newTestLogger(t, "panicTime")
+	defer os.RemoveAll(dir1)
+	l.cfg.TimeFormat, l.cfg.UTC = "2006-01-02 15:04:05.000", true
+	l.panicFormatter = JSONPanicFormatter
+	l.logPanic(0, "/a/b/main.go", 21, "out of cheese", getPanicStackTrace(), nil)
+	l.wtr.Close()
+	for _, line := range strings.Split(readLog(t, dir1, "panicTime"), "\n") {
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &report); err != nil {
+				t.Fatalf("%s: %s", err, line)
+			}
+		}
+	}
+	if ts, _ := report["timestamp"].(string); !strings.HasPrefix(ts, time.Now().UTC().Format("2006-01-02 ")) ||
+		len(ts) != len("2006-01-02 15:04:05.000") {
+		t.Errorf("timestamp %q", ts)
+	}
+	stack, _ := report["stack"].([]interface{})
+	found := false
+	for _, f := range stack {
+		frame := f.(map[string]interface{})
+		if strings.HasSuffix(frame["function"].(string), ".TestJSONPanicFormatter") {
+			found = true
+			if !strings.HasSuffix(frame["file"].(string), "log_test.go") || frame["line"].(float64) <= 0 {
+				t.Errorf("invalid frame %v", frame)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("panicking frame missing from stack %v", stack)
+	}
+}
+
+func TestFields(t *testing.T) {
+	l, dir := newTestLogger(t, "fields")
+	defer os.RemoveAll(dir)
+	l.cfg.Identity = true
+	l.cfg.Fields = map[string]string{"service": "billing", "zone": "eu west"}
+	l.setFields()
+
+	l.logMsg(0, "/a/main.go", 1, INFO, "one", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, WARNING, "two", nil, false, "", nil)
+	l.logExit(0, "/a/main.go", 3, 1, "three", nil)
+	l.wtr.Close()
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf(" hostname=%s pid=%d service=billing zone=\"eu west\" [", host, os.Getpid())
+	n := 0
+	for _, line := range strings.Split(readLog(t, dir, "fields"), "\n") {
+		if strings.Contains(line, "main.go") {
+			n++
+			if !strings.Contains(line, prefix) {
+				t.Errorf("fields missing from %q", line)
+			}
+		}
+	}
+	if n != 3 {
+		t.Errorf("%d messages", n)
+	}
+}
+
+func TestRotateOn(t *testing.T) {
+	l, dir := newTestLogger(t, "rotate")
+	defer os.RemoveAll(dir)
+	l.rotateOn = func(e Entry) bool {
+		return e.Priority == INFO && strings.HasPrefix(e.Message, "SESSION START")
+	}
+	l.logMsg(0, "/a/main.go", 1, INFO, "before session", nil, false, "", nil)
+	l.logMsg(0, "/a/main.go", 2, INFO, "SESSION START %d", []interface{}{1}, false, "", nil)
+	l.logMsg(0, "/a/main.go", 3, INFO, "in session", nil, false, "", nil)
+	l.wtr.Close()
+
+	logFiles := files.ListLogFiles(dir, "rotate")
+	if len(logFiles) != 2 {
+		t.Fatalf("%d log files", len(logFiles))
+	}
+	data, err := ioutil.ReadFile(logFiles[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	i := strings.Index(log, "SESSION START 1")
+	if i < 0 || strings.Contains(log, "before session") || strings.Index(log, "in session") < i {
+		t.Errorf("second log file:\n%s", log)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig()
+	cfg.RootDir = dir
+	cfg.FileName = "json"
+	cfg.Format = FormatJSON
+	cfg.Sequence = true
+	cfg.Fields = map[string]string{"service": "billing"}
+	l := new(logger)
+	if err := l.init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	l.logMsg(0, "/a/b/main.go", 14, INFO, "msg <%d>", []interface{}{1}, false, "",
+		[]*field{{"request_id", 42}})
+	l.logMsg(0, "/a/b/main.go", 15, PANIC, "panic", nil, false, "goroutine 1 [running]:\nmain.main()\n", nil)
+	l.logExit(0, "/a/b/main.go", 16, 3, "exit", nil)
+	l.wtr.Close()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(readLog(t, dir, "json"), "\n"), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%s: %q", err, line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("%d log lines", len(entries))
+	}
+	if _, ok := entries[0]["fileset"]; !ok {
+		t.Errorf("first line is not the file set header: %v", entries[0])
+	}
+	if config, ok := entries[1]["config"].(map[string]interface{}); !ok || config["Format"] != "json" {
+		t.Errorf("second line is not the log configuration: %v", entries[1])
+	}
+	exp := []map[string]interface{}{
+		{"level": "INFO", "file": "main.go", "line": 14.0, "msg": "msg <1>", "seq": 0.0, "service": "billing",
+			"request_id": 42.0},
+		{"level": "PANIC", "line": 15.0, "msg": "panic", "seq": 1.0,
+			"stacktrace": "goroutine 1 [running]:\nmain.main()"},
+		{"level": "EXIT", "line": 16.0, "msg": "exit", "seq": 2.0, "exitCode": 3.0},
+	}
+	for i, e := range exp {
+		entry := entries[i+2]
+		if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(entry["time"])); err != nil {
+			t.Errorf("entry %d: %s", i, err)
+		}
+		for k, v := range e {
+			if entry[k] != v {
+				t.Errorf("entry %d: %s = %v, expected %v", i, k, entry[k], v)
+			}
+		}
+	}
+}
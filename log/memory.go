@@ -0,0 +1,113 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"strings"
+	"sync"
+)
+
+/*
+MemorySink is an io.Writer that records the lines written to it, e.g. to capture the log of a
+test with SetOutput:
+
+	sink := log.NewMemorySink()
+	log.SetOutput(sink)
+	defer log.SetOutput(nil)
+	...
+	if !sink.Contains("connection refused") {
+		t.Error("connection error not logged")
+	}
+
+The methods of MemorySink, other than Write, flush the logger first, so that they include
+all messages logged before the call.
+*/
+type MemorySink struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+}
+
+// NewMemorySink returns a new empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+/*
+CaptureForTest directs the log to a new MemorySink for the duration of the test t and returns
+the sink. The log is directed back to the log files when the test completes:
+
+	func TestLogin(t *testing.T) {
+		sink := log.CaptureForTest(t)
+		...
+		fmt.Println(sink.Lines())
+	}
+*/
+func CaptureForTest(t interface{ Cleanup(func()) }) *MemorySink {
+	sink := NewMemorySink()
+	SetOutput(sink)
+	t.Cleanup(func() { SetOutput(nil) })
+	return sink
+}
+
+// Write records each complete line of buf without its line ending and returns len(buf).
+// An incomplete last line is completed by the next Write.
+func (s *MemorySink) Write(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := strings.Split(s.partial+string(buf), "\n")
+	s.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		s.lines = append(s.lines, strings.TrimSuffix(line, "\r"))
+	}
+	return len(buf), nil
+}
+
+// Lines returns a copy of the lines recorded by s
+func (s *MemorySink) Lines() []string {
+	Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// Contains returns true if a line recorded by s contains substr
+func (s *MemorySink) Contains(substr string) bool {
+	for _, line := range s.Lines() {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of lines recorded by s that contain substr
+func (s *MemorySink) Count(substr string) int {
+	n := 0
+	for _, line := range s.Lines() {
+		if strings.Contains(line, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset discards the lines recorded by s
+func (s *MemorySink) Reset() {
+	Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines, s.partial = nil, ""
+}
@@ -0,0 +1,147 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CrashReport contains the details of a message logged by Panic or Panicf
+type CrashReport struct {
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	File      string    `json:"file"`
+	Line      int       `json:"line"`
+	// Goroutine is the header of the stack trace, e.g.: "goroutine 1 [running]:"
+	Goroutine string `json:"goroutine"`
+	// Stack contains the frames of the stack trace, innermost frame first
+	Stack []*Frame `json:"stack"`
+	// Fields are the fields of a Logger returned by WithFields
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// timestamp is Timestamp in the time format of the logger
+	timestamp string
+}
+
+// MarshalJSON returns report as a JSON object with the timestamp in the time format of the
+// logger, see Config.TimeFormat and Config.UTC
+func (report *CrashReport) MarshalJSON() ([]byte, error) {
+	timestamp := report.timestamp
+	if timestamp == "" {
+		timestamp = report.Timestamp.Format(DefaultTimeFormat)
+	}
+	return json.Marshal(&struct {
+		Message   string                 `json:"message"`
+		Timestamp string                 `json:"timestamp"`
+		File      string                 `json:"file"`
+		Line      int                    `json:"line"`
+		Goroutine string                 `json:"goroutine"`
+		Stack     []*Frame               `json:"stack"`
+		Fields    map[string]interface{} `json:"fields,omitempty"`
+	}{report.Message, timestamp, report.File, report.Line, report.Goroutine, report.Stack, report.Fields})
+}
+
+// Frame is a function call frame of a stack trace
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+/*
+PanicFormatter returns the log entry of a panic. The returned entry is written to the log
+files of the PANIC priority instead of the default PANIC message and stack trace.
+*/
+type PanicFormatter func(report *CrashReport) string
+
+/*
+JSONPanicFormatter is a PanicFormatter that returns report as a single line of JSON, e.g.:
+
+	{"message":"...","timestamp":"...","file":"main.go","line":12,"goroutine":"goroutine 1 [running]:",
+	 "stack":[{"function":"main.main","file":"/src/app/main.go","line":12}]}
+*/
+func JSONPanicFormatter(report *CrashReport) string {
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	return string(buf) + "\n"
+}
+
+// newCrashReport returns the crash report of a panic with stack trace stackTrace, which has
+// the format of runtime.Stack.
+func newCrashReport(file string, line int, msg, stackTrace string) *CrashReport {
+	report := &CrashReport{
+		Message:   msg,
+		Timestamp: time.Now(),
+		File:      file,
+		Line:      line,
+		Stack:     []*Frame{},
+	}
+	lines := strings.Split(strings.TrimRight(stackTrace, "\n"), "\n")
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "goroutine ") {
+		report.Goroutine, lines = lines[0], lines[1:]
+	}
+	for i := 0; i < len(lines); i++ {
+		fn := lines[i]
+		if fn == "" {
+			// the stack traces of further goroutines are ignored
+			break
+		}
+		frame := &Frame{Function: frameFunction(fn)}
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+			i++
+			frame.File, frame.Line = frameFileLine(lines[i])
+		}
+		report.Stack = append(report.Stack, frame)
+	}
+	return report
+}
+
+// frameFunction returns the function name of a stack trace line, e.g.: "main.f(0x1, 0x2)"
+// or "created by main.main in goroutine 1"
+func frameFunction(line string) string {
+	if strings.HasPrefix(line, "created by ") {
+		line = strings.TrimPrefix(line, "created by ")
+		if i := strings.Index(line, " in goroutine "); i >= 0 {
+			line = line[:i]
+		}
+		return line
+	}
+	if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+		return line[:i]
+	}
+	return line
+}
+
+// frameFileLine returns the file and line number of a stack trace line, e.g.:
+// "\t/src/app/main.go:12 +0x1d"
+func frameFileLine(line string) (string, int) {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, " +0x"); i >= 0 {
+		line = line[:i]
+	}
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return line, 0
+	}
+	n, err := strconv.Atoi(line[i+1:])
+	if err != nil {
+		return line, 0
+	}
+	return line[:i], n
+}
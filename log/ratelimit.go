@@ -0,0 +1,120 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// callSite identifies the source line of a log call
+type callSite struct {
+	file string
+	line int
+}
+
+// rateBucket is the token bucket of the messages of a call site
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	// pc of the call site
+	pc uintptr
+	// priority of the last suppressed message
+	priority   Priority
+	suppressed uint64
+}
+
+// setRateLimit limits the messages of each call site to maxPerSecond. maxPerSecond <= 0 disables
+// rate limiting. The summaries of messages suppressed by the current rate limit are written.
+func (l *logger) setRateLimit(maxPerSecond int) {
+	l.logRateSummaries()
+	l.rates = nil
+	if l.rateTicker != nil {
+		l.rateTicker.Stop()
+		l.rateTicker = nil
+	}
+	if maxPerSecond < 0 {
+		maxPerSecond = 0
+	}
+	l.rateLimit = maxPerSecond
+}
+
+// rateLimited returns true if the message of priority from file, line at pc exceeds the rate
+// limit of its call site, in which case the message is counted in the summary of the call site.
+func (l *logger) rateLimited(pc uintptr, file string, line int, priority Priority) bool {
+	if l.rateLimit == 0 || priority <= PANIC {
+		return false
+	}
+	now := time.Now()
+	site := callSite{file, line}
+	b, exist := l.rates[site]
+	if !exist {
+		if l.rates == nil {
+			l.rates = make(map[callSite]*rateBucket)
+		}
+		b = &rateBucket{tokens: float64(l.rateLimit), last: now, pc: pc}
+		l.rates[site] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(l.rateLimit)
+	if b.tokens > float64(l.rateLimit) {
+		b.tokens = float64(l.rateLimit)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.priority = priority
+		b.suppressed++
+		return true
+	}
+	b.tokens--
+	return false
+}
+
+// rateTick returns the channel of the ticker of the rate limit summaries, or nil if rate
+// limiting is disabled.
+func (l *logger) rateTick() <-chan time.Time {
+	if l.rateLimit == 0 {
+		return nil
+	}
+	if l.rateTicker == nil {
+		l.rateTicker = time.NewTicker(time.Second)
+	}
+	return l.rateTicker.C
+}
+
+// logRateSummaries writes the number of messages suppressed from each call site since the last
+// summary, e.g.: "42 messages suppressed from main.go:14", and forgets idle call sites.
+func (l *logger) logRateSummaries() {
+	sites := make([]callSite, 0, len(l.rates))
+	for site, b := range l.rates {
+		if b.suppressed > 0 {
+			sites = append(sites, site)
+		} else if time.Since(b.last) > time.Second {
+			delete(l.rates, site)
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].file != sites[j].file {
+			return sites[i].file < sites[j].file
+		}
+		return sites[i].line < sites[j].line
+	})
+	for _, site := range sites {
+		b := l.rates[site]
+		msg := fmt.Sprintf("%d messages suppressed from %s:%d", b.suppressed, l.caller(site.file), site.line)
+		l.writeEntry(b.priority, l.entry(b.priority, 0, b.pc, site.file, site.line, msg, "", nil))
+		b.suppressed = 0
+	}
+}
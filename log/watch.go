@@ -0,0 +1,97 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+watch returns the channel of the events of the directory of the log config file, or nil if the
+log config file is not re-read or cannot be watched, in which case it is polled, see refresh.
+
+The directory is watched rather than the file, so that a config file created after the logger
+has started, or replaced by renaming a new version over it, is seen. The log config file is
+refreshed when the watch is established, for changes made before it was.
+*/
+func (l *logger) watch() <-chan fsnotify.Event {
+	if !l.reload || l.reloadDisabled || l.watchFailed {
+		l.stopWatch()
+		return nil
+	}
+	dir := configDir()
+	if l.watcher != nil && dir == l.watchDir {
+		return l.watcher.Events
+	}
+	l.stopWatch()
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = w.Add(dir); err != nil {
+			w.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error watching the log config file, polling it instead: %s\n", err)
+		l.watchFailed = true
+		return nil
+	}
+	l.watcher, l.watchDir = w, dir
+	l.refreshConfig()
+	return l.watcher.Events
+}
+
+// watchErrors returns the error channel of the watch of the log config file, or nil if it is not
+// watched
+func (l *logger) watchErrors() <-chan error {
+	if l.watcher == nil {
+		return nil
+	}
+	return l.watcher.Errors
+}
+
+// configEvent re-reads the log config file if ev is an event of the log config file
+func (l *logger) configEvent(ev fsnotify.Event) {
+	if isConfigFile(ev.Name) {
+		l.refreshConfig()
+	}
+}
+
+// watchError reports err and falls back to polling the log config file, in case events have
+// been lost
+func (l *logger) watchError(err error) {
+	fmt.Fprintf(os.Stderr, "Error watching the log config file, polling it instead: %s\n", err)
+	l.stopWatch()
+	l.watchFailed = true
+	l.refreshConfig()
+}
+
+func (l *logger) stopWatch() {
+	if l.watcher != nil {
+		l.watcher.Close()
+		l.watcher, l.watchDir = nil, ""
+	}
+}
+
+// configDir returns the directory of the log config file, see configFileNames
+func configDir() string {
+	if pth := os.Getenv(ConfigFileEnv); pth != "" {
+		return filepath.Dir(pth)
+	}
+	return "."
+}
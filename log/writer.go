@@ -0,0 +1,46 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package log
+
+import (
+	"io"
+	"strings"
+)
+
+/*
+Writer returns an io.Writer that logs every line written to it as a message of priority p, e.g.
+to collect the output of the standard library logger in the log files:
+
+	stdlog.SetOutput(log.Writer(log.WARNING))
+	srv := &http.Server{ErrorLog: stdlog.New(log.Writer(log.WARNING), "http: ", 0)}
+
+The messages are attributed to the caller of Write. p must be WARNING, INFO, DEBUG or TRACE.
+*/
+func Writer(p Priority) io.Writer {
+	return &priorityWriter{priority: p}
+}
+
+type priorityWriter struct {
+	priority Priority
+}
+
+// Write logs each line of buf and returns len(buf)
+func (w *priorityWriter) Write(buf []byte) (int, error) {
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	for _, line := range lines {
+		logSkipIF(0, w.priority, "%s", []interface{}{strings.TrimSuffix(line, "\r")}, nil)
+	}
+	return len(buf), nil
+}
@@ -0,0 +1,191 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package md
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+/*
+CodeBlock is a code section enclosed in triple backticks.
+*/
+type CodeBlock struct {
+	// InfoString is the text following the opening backticks on the same line,
+	// e.g.: `go title="main.go"`
+	InfoString string
+	// Lang is the language of the block, e.g.: "go". See ParseInfoString.
+	Lang string
+	// Attributes are the attributes of the info string. See ParseInfoString.
+	Attributes map[string]string
+	// Code is the text of the block following the info string line
+	Code string
+}
+
+/*
+GetBlocks returns the code sections of mdfile enclosed in triple backticks in the order
+in which they occur.
+*/
+func GetBlocks(mdfile string) ([]*CodeBlock, error) {
+	inbuf, err := ioutil.ReadFile(mdfile)
+	if err != nil {
+		return nil, err
+	}
+	input := []rune(string(inbuf))
+	var blocks []*CodeBlock
+	var code []rune
+	prev := textRune
+	scanMd(input, func(i int, k runeKind) {
+		switch {
+		case k == codeRune:
+			if prev == fenceRune {
+				code = code[:0]
+			}
+			code = append(code, input[i])
+		case k == fenceRune && prev == codeRune:
+			blocks = append(blocks, newCodeBlock(string(code)))
+		}
+		prev = k
+	})
+	return blocks, nil
+}
+
+/*
+GetSourceLang returns the code of the code sections of mdfile with language lang, compared
+case-insensitively, in the order in which they occur. The code of consecutive sections is
+separated by a newline.
+*/
+func GetSourceLang(mdfile, lang string) (string, error) {
+	blocks, err := GetBlocks(mdfile)
+	if err != nil {
+		return "", err
+	}
+	var src strings.Builder
+	first := true
+	for _, b := range blocks {
+		if !strings.EqualFold(b.Lang, lang) {
+			continue
+		}
+		if !first {
+			src.WriteString("\n")
+		}
+		first = false
+		src.WriteString(b.Code)
+	}
+	return src.String(), nil
+}
+
+// newCodeBlock returns the CodeBlock of the text between the backticks of a code section
+func newCodeBlock(text string) *CodeBlock {
+	b := &CodeBlock{}
+	if i := strings.Index(text, "\n"); i >= 0 {
+		b.InfoString, b.Code = strings.TrimSpace(text[:i]), text[i+1:]
+	} else {
+		b.InfoString = strings.TrimSpace(text)
+	}
+	b.Lang, b.Attributes = ParseInfoString(b.InfoString)
+	return b
+}
+
+/*
+FileName returns the file name or title attribute of b, or "" if b has neither. The attributes
+"filename", "file", "path" and "title" are tried in that order.
+*/
+func (b *CodeBlock) FileName() string {
+	for _, key := range []string{"filename", "file", "path", "title"} {
+		if v := b.Attributes[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+/*
+ParseInfoString returns the language and attributes of the info string of a code block.
+The language is the first word of info. The attributes are the following key=value pairs,
+with optionally quoted values, which may be enclosed in braces. A word without a value has
+the value "". If the language is followed by a colon, the text after the colon is the value
+of the "file" attribute. E.g.:
+
+	go title="main.go"          -> "go", {title: main.go}
+	go:cmd/app/main.go          -> "go", {file: cmd/app/main.go}
+	go {file='a b.go' linenos}  -> "go", {file: a b.go, linenos: ""}
+*/
+func ParseInfoString(info string) (lang string, attributes map[string]string) {
+	attributes = make(map[string]string)
+	words := splitInfoString(info)
+	if len(words) == 0 {
+		return "", attributes
+	}
+	lang, words = words[0], words[1:]
+	if strings.ContainsAny(lang, "={") {
+		// the info string has attributes but no language
+		words, lang = append([]string{lang}, words...), ""
+	} else if i := strings.Index(lang, ":"); i >= 0 {
+		lang, attributes["file"] = lang[:i], lang[i+1:]
+	}
+	for _, w := range words {
+		w = strings.Trim(w, "{}")
+		if w == "" {
+			continue
+		}
+		if i := strings.Index(w, "="); i >= 0 {
+			attributes[w[:i]] = unquote(w[i+1:])
+		} else {
+			attributes[w] = ""
+		}
+	}
+	return lang, attributes
+}
+
+// splitInfoString returns the space separated words of info. Spaces in quoted values are
+// not separators.
+func splitInfoString(info string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	for _, r := range info {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			word.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// unquote removes matching enclosing quotes from v
+func unquote(v string) string {
+	v = strings.TrimRight(v, "}")
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
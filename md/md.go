@@ -13,7 +13,7 @@
 //  limitations under the License.
 
 /*
-Package md extracts code sections of markdown files
+Package md extracts code sections of markdown files, or the text outside them
 */
 package md
 
@@ -36,24 +36,63 @@ func GetSource(mdfile string) (string, error) {
 	return string(input), nil
 }
 
+/*
+GetProse returns the text of mdfile with the code sections enclosed in triple backticks,
+including the backticks, removed. Code sections that are only indented are retained.
+*/
+func GetProse(mdfile string) (string, error) {
+	inbuf, err := ioutil.ReadFile(mdfile)
+	if err != nil {
+		return "", err
+	}
+	input := []rune(string(inbuf))
+	prose := make([]rune, 0, len(input))
+	scanMd(input, func(i int, k runeKind) {
+		if k == textRune {
+			prose = append(prose, input[i])
+		}
+	})
+	return string(prose), nil
+}
+
 func loadMd(input []rune) {
+	scanMd(input, func(i int, k runeKind) {
+		switch k {
+		case fenceRune:
+			input[i] = ' '
+		case textRune:
+			if input[i] != '\n' {
+				input[i] = ' '
+			}
+		}
+	})
+}
+
+type runeKind int
+
+const (
+	textRune runeKind = iota
+	fenceRune
+	codeRune
+)
+
+// scanMd calls emit with the index and kind of every rune of input
+func scanMd(input []rune, emit func(i int, k runeKind)) {
 	i := 0
 	text := true
 	for i < len(input) {
 		if i <= len(input)-3 && input[i] == '`' && input[i+1] == '`' && input[i+2] == '`' {
 			text = !text
 			for j := 0; j < 3; j++ {
-				input[i+j] = ' '
+				emit(i+j, fenceRune)
 			}
 			i += 3
 		}
 		if i < len(input) {
 			if text {
-				if input[i] == '\n' {
-					input[i] = '\n'
-				} else {
-					input[i] = ' '
-				}
+				emit(i, textRune)
+			} else {
+				emit(i, codeRune)
 			}
 			i += 1
 		}
@@ -0,0 +1,154 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package md
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const doc = "# Title\n" +
+	"Some prose.\n" +
+	"```\n" +
+	"A : B b | c ;\n" +
+	"```\n" +
+	"More prose.\n" +
+	"```go\n" +
+	"func main() {}\n" +
+	"```\n" +
+	"The end.\n"
+
+// writeMd writes md to a temporary file and returns its name
+func writeMd(t *testing.T, md string) string {
+	f, err := ioutil.TempFile("", "md_test*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(md); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestGetSource(t *testing.T) {
+	fname := writeMd(t, doc)
+	defer os.Remove(fname)
+	src, err := GetSource(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src) != len(doc) {
+		t.Errorf("len(src) = %d, expected %d", len(src), len(doc))
+	}
+	if strings.Contains(src, "prose") || !strings.Contains(src, "A : B b | c ;") ||
+		!strings.Contains(src, "func main() {}") {
+		t.Errorf("source:\n%s", src)
+	}
+}
+
+func TestGetProse(t *testing.T) {
+	fname := writeMd(t, doc)
+	defer os.Remove(fname)
+	prose, err := GetProse(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "# Title\nSome prose.\n\nMore prose.\n\nThe end.\n"
+	if prose != exp {
+		t.Errorf("prose:\n%q\nexpected:\n%q", prose, exp)
+	}
+}
+
+func TestGetBlocks(t *testing.T) {
+	fname := writeMd(t, doc+"```go title=\"main.go\"\npackage main\n```\n")
+	defer os.Remove(fname)
+	blocks, err := GetBlocks(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("%d blocks", len(blocks))
+	}
+	exp := []CodeBlock{
+		{InfoString: "", Lang: "", Code: "A : B b | c ;\n"},
+		{InfoString: "go", Lang: "go", Code: "func main() {}\n"},
+		{InfoString: `go title="main.go"`, Lang: "go", Code: "package main\n"},
+	}
+	for i, b := range blocks {
+		if b.InfoString != exp[i].InfoString || b.Lang != exp[i].Lang || b.Code != exp[i].Code {
+			t.Errorf("block %d = %+v", i, b)
+		}
+	}
+	if fn := blocks[2].FileName(); fn != "main.go" {
+		t.Errorf("FileName = %q", fn)
+	}
+	if fn := blocks[1].FileName(); fn != "" {
+		t.Errorf("FileName of block without attributes = %q", fn)
+	}
+}
+
+func TestParseInfoString(t *testing.T) {
+	tests := []struct {
+		info, lang, fileName string
+		attrs                map[string]string
+	}{
+		{`go title="main.go"`, "go", "main.go", map[string]string{"title": "main.go"}},
+		{"go:path/to/file.go", "go", "path/to/file.go", map[string]string{"file": "path/to/file.go"}},
+		{`go {filename='a b.go' linenos}`, "go", "a b.go", map[string]string{"filename": "a b.go", "linenos": ""}},
+		{"python file=x.py hl=1-3", "python", "x.py", map[string]string{"file": "x.py", "hl": "1-3"}},
+		{`{title="notes"}`, "", "notes", map[string]string{"title": "notes"}},
+		{"", "", "", map[string]string{}},
+	}
+	for _, test := range tests {
+		lang, attrs := ParseInfoString(test.info)
+		b := &CodeBlock{Attributes: attrs}
+		if lang != test.lang || b.FileName() != test.fileName || len(attrs) != len(test.attrs) {
+			t.Errorf("ParseInfoString(%q) = %q, %v", test.info, lang, attrs)
+			continue
+		}
+		for k, v := range test.attrs {
+			if attrs[k] != v {
+				t.Errorf("ParseInfoString(%q): %s = %q, expected %q", test.info, k, attrs[k], v)
+			}
+		}
+	}
+}
+
+func TestGetSourceLang(t *testing.T) {
+	fname := writeMd(t, doc+
+		"```bash\n"+
+		"go build\n"+
+		"```\n"+
+		"```Go\n"+
+		"func f() {\n"+
+		"\treturn\n"+
+		"}\n"+
+		"```\n")
+	defer os.Remove(fname)
+	src, err := GetSourceLang(fname, "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "func main() {}\n\nfunc f() {\n\treturn\n}\n"
+	if src != exp {
+		t.Errorf("source:\n%q\nexpected:\n%q", src, exp)
+	}
+	if src, err := GetSourceLang(fname, "python"); err != nil || src != "" {
+		t.Errorf("python source %q, %v", src, err)
+	}
+}
@@ -0,0 +1,121 @@
+//  Copyright 2020 Marius Ackerman
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package stringset
+
+/*
+OrderedStringSet implements a set of strings that preserves the order in which elements
+were first added
+*/
+type OrderedStringSet struct {
+	elements []string
+	index    map[string]int
+}
+
+// NewOrdered returns a new OrderedStringSet containing elements in order of first appearance
+func NewOrdered(elements ...string) *OrderedStringSet {
+	set := &OrderedStringSet{index: make(map[string]int)}
+	set.Add(elements...)
+	return set
+}
+
+/*
+Add the elements that are not yet in ss to the end of ss and return ss to allow chained
+commands
+*/
+func (ss *OrderedStringSet) Add(elements ...string) *OrderedStringSet {
+	for _, e := range elements {
+		if _, exist := ss.index[e]; !exist {
+			ss.index[e] = len(ss.elements)
+			ss.elements = append(ss.elements, e)
+		}
+	}
+	return ss
+}
+
+/*
+Contain returns true iff ss contains s
+*/
+func (ss *OrderedStringSet) Contain(s string) bool {
+	_, exist := ss.index[s]
+	return exist
+}
+
+/*
+Difference returns a new set containing the elements of ss that are not in ss1, in the order
+of ss
+*/
+func (ss *OrderedStringSet) Difference(ss1 *OrderedStringSet) *OrderedStringSet {
+	diff := NewOrdered()
+	for _, e := range ss.elements {
+		if !ss1.Contain(e) {
+			diff.Add(e)
+		}
+	}
+	return diff
+}
+
+/*
+Elements returns a slice containing the elements of ss in order
+*/
+func (ss *OrderedStringSet) Elements() []string {
+	sl := make([]string, len(ss.elements))
+	copy(sl, ss.elements)
+	return sl
+}
+
+/*
+Intersection returns a new set containing the elements of ss that are also in ss1, in the
+order of ss
+*/
+func (ss *OrderedStringSet) Intersection(ss1 *OrderedStringSet) *OrderedStringSet {
+	is := NewOrdered()
+	for _, e := range ss.elements {
+		if ss1.Contain(e) {
+			is.Add(e)
+		}
+	}
+	return is
+}
+
+/*
+Len returns the number of elements in ss
+*/
+func (ss *OrderedStringSet) Len() int {
+	return len(ss.elements)
+}
+
+/*
+Remove element from ss and return ss to allow chained commands
+*/
+func (ss *OrderedStringSet) Remove(element string) *OrderedStringSet {
+	i, exist := ss.index[element]
+	if !exist {
+		return ss
+	}
+	delete(ss.index, element)
+	ss.elements = append(ss.elements[:i], ss.elements[i+1:]...)
+	for j := i; j < len(ss.elements); j++ {
+		ss.index[ss.elements[j]] = j
+	}
+	return ss
+}
+
+/*
+Union returns a new set containing the elements of ss in the order of ss, followed by the
+elements of ss1 that are not in ss in the order of ss1
+*/
+func (ss *OrderedStringSet) Union(ss1 *OrderedStringSet) *OrderedStringSet {
+	return NewOrdered(ss.elements...).Add(ss1.elements...)
+}
@@ -17,7 +17,11 @@ Package stringset: Operations on a set of strings
 */
 package stringset
 
-import "sort"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 /*
 StringSet implements a set of strings
@@ -26,6 +30,8 @@ type StringSet struct {
 	set map[string]bool
 }
 
+var _ fmt.Stringer = (*StringSet)(nil)
+
 // New returns a new StringSet containing elements
 func New(elements ...string) *StringSet {
 	set := &StringSet{make(map[string]bool)}
@@ -33,6 +39,12 @@ func New(elements ...string) *StringSet {
 	return set
 }
 
+// NewFromSlice returns a new StringSet containing the elements of ss
+func NewFromSlice(ss []string) *StringSet {
+	set := &StringSet{make(map[string]bool, len(ss))}
+	return set.AddSlice(ss)
+}
+
 /*
 Add elements to ss and return ss to allow chained commands
 */
@@ -43,11 +55,38 @@ func (ss *StringSet) Add(elements ...string) *StringSet {
 	return ss
 }
 
+/*
+AddSlice adds the elements of sl to ss and returns ss to allow chained commands.
+If ss is empty its storage is allocated for len(sl) elements.
+*/
+func (ss *StringSet) AddSlice(sl []string) *StringSet {
+	if len(ss.set) == 0 && len(sl) > 0 {
+		ss.set = make(map[string]bool, len(sl))
+	}
+	for _, e := range sl {
+		ss.set[e] = true
+	}
+	return ss
+}
+
 /*
 AddSet adds the elements of ss1 to ss and returns ss to allow chained commands
 */
 func (ss *StringSet) AddSet(ss1 *StringSet) *StringSet {
-	ss.Add(ss1.Elements()...)
+	for s := range ss1.set {
+		ss.set[s] = true
+	}
+	return ss
+}
+
+/*
+Clear removes all elements from ss, keeping its storage, and returns ss to allow chained
+commands
+*/
+func (ss *StringSet) Clear() *StringSet {
+	for s := range ss.set {
+		delete(ss.set, s)
+	}
 	return ss
 }
 
@@ -55,7 +94,8 @@ func (ss *StringSet) AddSet(ss1 *StringSet) *StringSet {
 Clone returns a deep copy of ss
 */
 func (ss *StringSet) Clone() *StringSet {
-	return New().Add(ss.Elements()...)
+	clone := &StringSet{make(map[string]bool, len(ss.set))}
+	return clone.AddSet(ss)
 }
 
 /*
@@ -66,6 +106,79 @@ func (ss *StringSet) Contain(s string) bool {
 	return exist
 }
 
+/*
+ContainsAll returns true iff ss contains every one of elems. It returns true if elems is empty.
+*/
+func (ss *StringSet) ContainsAll(elems ...string) bool {
+	for _, s := range elems {
+		if !ss.Contain(s) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ContainsAny returns true iff ss contains at least one of elems. It returns false if elems is
+empty.
+*/
+func (ss *StringSet) ContainsAny(elems ...string) bool {
+	for _, s := range elems {
+		if ss.Contain(s) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Diff returns the changes needed to make ss equal to desired: toAdd contains the elements of
+desired that are not in ss and toRemove the elements of ss that are not in desired.
+*/
+func (ss *StringSet) Diff(desired *StringSet) (toAdd, toRemove *StringSet) {
+	toAdd, toRemove = New(), New()
+	for s := range desired.set {
+		if !ss.Contain(s) {
+			toAdd.Add(s)
+		}
+	}
+	for s := range ss.set {
+		if !desired.Contain(s) {
+			toRemove.Add(s)
+		}
+	}
+	return
+}
+
+/*
+Difference returns a new set containing the elements of ss that are not in ss1
+*/
+func (ss *StringSet) Difference(ss1 *StringSet) *StringSet {
+	diff := New()
+	for s := range ss.set {
+		if !ss1.Contain(s) {
+			diff.set[s] = true
+		}
+	}
+	return diff
+}
+
+/*
+Disjoint returns true iff ss and ss1 have no elements in common
+*/
+func (ss *StringSet) Disjoint(ss1 *StringSet) bool {
+	small, large := ss, ss1
+	if small.Len() > large.Len() {
+		small, large = large, small
+	}
+	for s := range small.set {
+		if large.Contain(s) {
+			return false
+		}
+	}
+	return true
+}
+
 /*
 Elements returns a slice containing the elements of ss
 */
@@ -77,6 +190,13 @@ func (ss *StringSet) Elements() []string {
 	return sl
 }
 
+/*
+ToSlice returns a slice containing the elements of ss. It is the same as Elements.
+*/
+func (ss *StringSet) ToSlice() []string {
+	return ss.Elements()
+}
+
 /*
 ElementsSorted returns a slice containing the elements of ss sorted lexicographically
 */
@@ -101,6 +221,59 @@ func (ss *StringSet) Equal(ss1 *StringSet) bool {
 	return true
 }
 
+/*
+ForEach calls f for each element of ss, in no particular order, until f returns false. Unlike
+Elements ForEach does not allocate. f must not add elements to ss.
+*/
+func (ss *StringSet) ForEach(f func(string) bool) {
+	for s := range ss.set {
+		if !f(s) {
+			return
+		}
+	}
+}
+
+/*
+Intersection returns a new set containing the elements that are in both ss and ss1
+*/
+func (ss *StringSet) Intersection(ss1 *StringSet) *StringSet {
+	small, large := ss, ss1
+	if small.Len() > large.Len() {
+		small, large = large, small
+	}
+	is := New()
+	for s := range small.set {
+		if large.Contain(s) {
+			is.set[s] = true
+		}
+	}
+	return is
+}
+
+/*
+IsSubset returns true iff every element of ss is in of. A set is a subset of itself and the
+empty set is a subset of every set.
+*/
+func (ss *StringSet) IsSubset(of *StringSet) bool {
+	if ss.Len() > of.Len() {
+		return false
+	}
+	for s := range ss.set {
+		if !of.Contain(s) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+IsSuperset returns true iff every element of sub is in ss. A set is a superset of itself and
+every set is a superset of the empty set.
+*/
+func (ss *StringSet) IsSuperset(sub *StringSet) bool {
+	return sub.IsSubset(ss)
+}
+
 /*
 Len returns the number of elements in ss
 */
@@ -115,3 +288,57 @@ func (ss *StringSet) Remove(element string) *StringSet {
 	delete(ss.set, element)
 	return ss
 }
+
+/*
+RemoveSet removes the elements of ss1 from ss and returns ss to allow chained commands
+*/
+func (ss *StringSet) RemoveSet(ss1 *StringSet) *StringSet {
+	for s := range ss1.set {
+		delete(ss.set, s)
+	}
+	return ss
+}
+
+/*
+String returns the elements of ss sorted lexicographically in the format {a, b, c}
+*/
+func (ss *StringSet) String() string {
+	elements := ss.ElementsSorted()
+	n := len("{}")
+	for _, s := range elements {
+		n += len(s) + len(", ")
+	}
+	var sb strings.Builder
+	sb.Grow(n)
+	sb.WriteByte('{')
+	for i, s := range elements {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(s)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+/*
+SymmetricDifference returns a new set containing the elements that are in exactly one of ss
+and ss1
+*/
+func (ss *StringSet) SymmetricDifference(ss1 *StringSet) *StringSet {
+	diff := ss.Difference(ss1)
+	for s := range ss1.set {
+		if !ss.Contain(s) {
+			diff.set[s] = true
+		}
+	}
+	return diff
+}
+
+/*
+Union returns a new set containing the elements of ss and ss1
+*/
+func (ss *StringSet) Union(ss1 *StringSet) *StringSet {
+	union := &StringSet{make(map[string]bool, ss.Len()+ss1.Len())}
+	return union.AddSet(ss).AddSet(ss1)
+}
@@ -15,6 +15,8 @@
 package stringset
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/goccmack/goutil/stringslice"
@@ -26,7 +28,7 @@ var (
 
 func Test1(t *testing.T) {
 	ss := New().Add(s1...)
-	if !stringslice.Equal(s1, ss.List()) {
+	if !stringslice.Equal(s1, ss.Elements()) {
 		t.Fail()
 	}
 }
@@ -54,3 +56,348 @@ func Test3(t *testing.T) {
 		}
 	}
 }
+
+func TestOrderedStringSet(t *testing.T) {
+	ss := NewOrdered("c", "a", "c", "b", "a")
+	if !reflect.DeepEqual(ss.Elements(), []string{"c", "a", "b"}) {
+		t.Errorf("elements %v", ss.Elements())
+	}
+	ss.Remove("a").Add("a", "d")
+	if !reflect.DeepEqual(ss.Elements(), []string{"c", "b", "a", "d"}) || !ss.Contain("a") || ss.Len() != 4 {
+		t.Errorf("elements %v after remove and add", ss.Elements())
+	}
+}
+
+func TestOrderedStringSetAlgebra(t *testing.T) {
+	left := NewOrdered("d", "b", "a", "e")
+	right := NewOrdered("c", "a", "f", "d")
+	tests := []struct {
+		name     string
+		set      *OrderedStringSet
+		expected []string
+	}{
+		{"union", left.Union(right), []string{"d", "b", "a", "e", "c", "f"}},
+		{"reverse union", right.Union(left), []string{"c", "a", "f", "d", "b", "e"}},
+		{"intersection", left.Intersection(right), []string{"d", "a"}},
+		{"reverse intersection", right.Intersection(left), []string{"a", "d"}},
+		{"difference", left.Difference(right), []string{"b", "e"}},
+		{"reverse difference", right.Difference(left), []string{"c", "f"}},
+		{"empty union", NewOrdered().Union(left), []string{"d", "b", "a", "e"}},
+		{"empty intersection", left.Intersection(NewOrdered()), []string{}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.set.Elements(), test.expected) {
+			t.Errorf("%s: %v, expected %v", test.name, test.set.Elements(), test.expected)
+		}
+	}
+	if !reflect.DeepEqual(left.Elements(), []string{"d", "b", "a", "e"}) {
+		t.Errorf("left modified: %v", left.Elements())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	actual := New("a", "b", "c")
+	desired := New("b", "c", "d", "e")
+	toAdd, toRemove := actual.Diff(desired)
+	if !toAdd.Equal(New("d", "e")) {
+		t.Errorf("toAdd %v", toAdd.ElementsSorted())
+	}
+	if !toRemove.Equal(New("a")) {
+		t.Errorf("toRemove %v", toRemove.ElementsSorted())
+	}
+	if !actual.Clone().Add(toAdd.Elements()...).Remove("a").Equal(desired) {
+		t.Error("applying the diff does not give desired")
+	}
+
+	toAdd, toRemove = actual.Diff(actual.Clone())
+	if toAdd.Len() != 0 || toRemove.Len() != 0 {
+		t.Errorf("diff of equal sets: %v, %v", toAdd.Elements(), toRemove.Elements())
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	left := New("a", "b", "d", "e")
+	right := New("a", "c", "d", "f")
+	disjoint := New("x", "y")
+	tests := []struct {
+		name     string
+		set      *StringSet
+		expected *StringSet
+	}{
+		{"union", left.Union(right), New("a", "b", "c", "d", "e", "f")},
+		{"intersection", left.Intersection(right), New("a", "d")},
+		{"reverse intersection", right.Intersection(left), New("a", "d")},
+		{"difference", left.Difference(right), New("b", "e")},
+		{"reverse difference", right.Difference(left), New("c", "f")},
+		{"empty union", New().Union(left), left},
+		{"union with empty", left.Union(New()), left},
+		{"empty intersection", left.Intersection(New()), New()},
+		{"empty difference", New().Difference(left), New()},
+		{"difference with empty", left.Difference(New()), left},
+		{"disjoint union", left.Union(disjoint), New("a", "b", "d", "e", "x", "y")},
+		{"disjoint intersection", left.Intersection(disjoint), New()},
+		{"disjoint difference", left.Difference(disjoint), left},
+		{"self difference", left.Difference(left), New()},
+		{"symmetric difference", left.SymmetricDifference(right), New("b", "c", "e", "f")},
+		{"reverse symmetric difference", right.SymmetricDifference(left), New("b", "c", "e", "f")},
+		{"empty symmetric difference", New().SymmetricDifference(left), left},
+		{"disjoint symmetric difference", left.SymmetricDifference(disjoint), left.Union(disjoint)},
+		{"self symmetric difference", left.SymmetricDifference(left), New()},
+	}
+	for _, test := range tests {
+		if !test.set.Equal(test.expected) {
+			t.Errorf("%s: %v, expected %v", test.name, test.set.ElementsSorted(), test.expected.ElementsSorted())
+		}
+	}
+	if !left.Equal(New("a", "b", "d", "e")) || !right.Equal(New("a", "c", "d", "f")) {
+		t.Errorf("operands modified: %v, %v", left.ElementsSorted(), right.ElementsSorted())
+	}
+}
+
+func TestDisjoint(t *testing.T) {
+	tests := []struct {
+		ss, ss1  *StringSet
+		expected bool
+	}{
+		{New("a", "b"), New("c", "d", "e"), true},
+		{New("a", "b"), New("c", "b", "e"), false},
+		{New("a", "b", "c"), New("c"), false},
+		{New("a"), New("a"), false},
+		{New(), New("a"), true},
+		{New(), New(), true},
+	}
+	for _, test := range tests {
+		if test.ss.Disjoint(test.ss1) != test.expected || test.ss1.Disjoint(test.ss) != test.expected {
+			t.Errorf("%v disjoint %v, expected %t", test.ss.ElementsSorted(), test.ss1.ElementsSorted(), test.expected)
+		}
+	}
+	// Disjoint iterates the smaller set without allocating an intersection
+	large, small := New(benchElements(100)...), New("x")
+	if n := testing.AllocsPerRun(100, func() { large.Disjoint(small) }); n != 0 {
+		t.Errorf("Disjoint allocates %f times", n)
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	abc := New("a", "b", "c")
+	tests := []struct {
+		sub, super *StringSet
+		expected   bool
+	}{
+		{New("a", "c"), abc, true},
+		{abc, abc, true},
+		{abc.Clone(), abc, true},
+		{New(), abc, true},
+		{New(), New(), true},
+		{New("a", "d"), abc, false},
+		{New("a", "b", "c", "d"), abc, false},
+		{abc, New(), false},
+	}
+	for _, test := range tests {
+		if test.sub.IsSubset(test.super) != test.expected {
+			t.Errorf("%v subset of %v, expected %t", test.sub.ElementsSorted(), test.super.ElementsSorted(), test.expected)
+		}
+		if test.super.IsSuperset(test.sub) != test.expected {
+			t.Errorf("%v superset of %v, expected %t", test.super.ElementsSorted(), test.sub.ElementsSorted(), test.expected)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, test := range []struct {
+		ss       *StringSet
+		expected string
+	}{
+		{New(), "{}"},
+		{New("a"), "{a}"},
+		{New("c", "a", "b"), "{a, b, c}"},
+	} {
+		if s := test.ss.String(); s != test.expected {
+			t.Errorf("%q, expected %q", s, test.expected)
+		}
+	}
+	if s := fmt.Sprintf("%v", New("b", "a")); s != "{a, b}" {
+		t.Errorf("%%v of set: %s", s)
+	}
+}
+
+func TestContainsAllAny(t *testing.T) {
+	ss := New("read", "write", "admin")
+	tests := []struct {
+		elems    []string
+		all, any bool
+	}{
+		{nil, true, false},
+		{[]string{"read"}, true, true},
+		{[]string{"read", "write"}, true, true},
+		{[]string{"read", "delete"}, false, true},
+		{[]string{"delete", "create"}, false, false},
+	}
+	for _, test := range tests {
+		if all := ss.ContainsAll(test.elems...); all != test.all {
+			t.Errorf("ContainsAll(%v) = %t", test.elems, all)
+		}
+		if any := ss.ContainsAny(test.elems...); any != test.any {
+			t.Errorf("ContainsAny(%v) = %t", test.elems, any)
+		}
+	}
+	if New().ContainsAny() || !New().ContainsAll() {
+		t.Error("empty set with no elements")
+	}
+}
+
+func TestRemoveSet(t *testing.T) {
+	tokens := New("t1", "t2", "t3", "t4")
+	if !tokens.RemoveSet(New("t2", "t4", "t5")).Equal(New("t1", "t3")) {
+		t.Errorf("tokens %v after RemoveSet", tokens)
+	}
+	if !tokens.RemoveSet(New()).Equal(New("t1", "t3")) {
+		t.Errorf("tokens %v after removing the empty set", tokens)
+	}
+	if tokens.RemoveSet(tokens).Len() != 0 {
+		t.Errorf("tokens %v after removing themselves", tokens)
+	}
+}
+
+func TestClear(t *testing.T) {
+	ss := New(benchElements(100)...)
+	if ss.Clear().Len() != 0 || ss.Contain("e1") {
+		t.Errorf("%v after Clear", ss)
+	}
+	if !ss.Add("a").Equal(New("a")) {
+		t.Errorf("%v after Clear and Add", ss)
+	}
+	if n := testing.AllocsPerRun(100, func() { ss.Add("a").Clear() }); n != 0 {
+		t.Errorf("Clear allocates %f times", n)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	ss := New(benchElements(100)...)
+	visited := New()
+	ss.ForEach(func(s string) bool {
+		visited.Add(s)
+		return true
+	})
+	if !visited.Equal(ss) {
+		t.Errorf("ForEach visited %d elements", visited.Len())
+	}
+	n := 0
+	ss.ForEach(func(s string) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("ForEach did not stop: %d calls", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { ss.ForEach(func(string) bool { return true }) }); n != 0 {
+		t.Errorf("ForEach allocates %f times", n)
+	}
+}
+
+func TestContainNoAlloc(t *testing.T) {
+	ss := New(benchElements(100)...)
+	if n := testing.AllocsPerRun(100, func() { ss.Contain("e50") }); n != 0 {
+		t.Errorf("Contain allocates %f times", n)
+	}
+	ss1 := New(benchElements(10)...)
+	if n := testing.AllocsPerRun(100, func() { ss.AddSet(ss1) }); n != 0 {
+		t.Errorf("AddSet allocates %f times", n)
+	}
+}
+
+// benchElements returns n distinct elements
+func benchElements(n int) []string {
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = fmt.Sprintf("e%d", i)
+	}
+	return elements
+}
+
+func BenchmarkAdd(b *testing.B) {
+	elements := benchElements(1000)
+	ss := New()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Add(elements[i%len(elements)])
+	}
+}
+
+func BenchmarkContain(b *testing.B) {
+	elements := benchElements(1000)
+	ss := New(elements...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Contain(elements[i%len(elements)])
+	}
+}
+
+func BenchmarkContainParallel(b *testing.B) {
+	elements := benchElements(1000)
+	ss := New(elements...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			ss.Contain(elements[i%len(elements)])
+		}
+	})
+}
+
+func BenchmarkElements(b *testing.B) {
+	ss := New(benchElements(1000)...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Elements()
+	}
+}
+
+func BenchmarkOrderedIntersection(b *testing.B) {
+	elements := benchElements(1000)
+	ss, ss1 := NewOrdered(elements...), NewOrdered(elements[500:]...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Intersection(ss1)
+	}
+}
+
+func TestNewFromSlice(t *testing.T) {
+	elements := append(benchElements(100), "e1", "e2")
+	ss := NewFromSlice(elements)
+	if !ss.Equal(New(elements...)) || ss.Len() != 100 {
+		t.Errorf("NewFromSlice has %d elements", ss.Len())
+	}
+	ss = New("x").AddSlice(elements)
+	if !ss.Equal(New(elements...).Add("x")) {
+		t.Errorf("AddSlice has %d elements", ss.Len())
+	}
+	if ss := NewFromSlice(nil); ss.Len() != 0 || len(ss.ToSlice()) != 0 {
+		t.Error("NewFromSlice(nil) is not empty")
+	}
+}
+
+func BenchmarkNewFromSlice(b *testing.B) {
+	elements := benchElements(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewFromSlice(elements)
+	}
+}
+
+func BenchmarkRepeatedAdd(b *testing.B) {
+	elements := benchElements(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss := New()
+		for _, e := range elements {
+			ss.Add(e)
+		}
+	}
+}
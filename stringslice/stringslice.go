@@ -17,6 +17,7 @@ package stringslice
 
 import (
 	"regexp"
+	"strings"
 )
 
 // Clone returns a clone of s1
@@ -92,6 +93,32 @@ func Diff(a, b []string) (diff []string) {
 	return
 }
 
+/*
+DiffReport returns the elements of a that are not in b and the elements of b that are not in a.
+a and b are compared as sets: each element is reported once, in order of its first occurrence,
+irrespective of the number of times it occurs in a or b.
+onlyA and onlyB are both empty if and only if a and b contain the same set of elements.
+*/
+func DiffReport(a, b []string) (onlyA, onlyB []string) {
+	return setDiff(a, b), setDiff(b, a)
+}
+
+// setDiff returns the distinct elements of a that are not in b
+func setDiff(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, e := range b {
+		inB[e] = true
+	}
+	diff := []string{}
+	for _, e := range a {
+		if !inB[e] {
+			diff = append(diff, e)
+			inB[e] = true
+		}
+	}
+	return diff
+}
+
 /*
 RemoveDuplicates returns a slice containing one instance of every string in in.
 The order of strings returned is random.
@@ -118,3 +145,139 @@ func Reverse(ss []string) []string {
 	}
 	return rev
 }
+
+/*
+RotateLeft returns a new slice containing the elements of ss rotated left by k positions,
+i.e.: the element at index k of ss is at index 0 of the result.
+k is taken modulo len(ss). A negative k rotates right.
+*/
+func RotateLeft(ss []string, k int) []string {
+	rot := make([]string, len(ss))
+	if len(ss) == 0 {
+		return rot
+	}
+	k %= len(ss)
+	if k < 0 {
+		k += len(ss)
+	}
+	n := copy(rot, ss[k:])
+	copy(rot[n:], ss[:k])
+	return rot
+}
+
+/*
+RotateRight returns a new slice containing the elements of ss rotated right by k positions,
+i.e.: the element at index 0 of ss is at index k of the result.
+k is taken modulo len(ss). A negative k rotates left.
+*/
+func RotateRight(ss []string, k int) []string {
+	if len(ss) == 0 {
+		return []string{}
+	}
+	return RotateLeft(ss, len(ss)-k%len(ss))
+}
+
+/*
+Flatten returns a new slice containing the elements of all the slices of sss in order.
+*/
+func Flatten(sss [][]string) []string {
+	n := 0
+	for _, ss := range sss {
+		n += len(ss)
+	}
+	flat := make([]string, 0, n)
+	for _, ss := range sss {
+		flat = append(flat, ss...)
+	}
+	return flat
+}
+
+/*
+MoveToFront returns a new slice containing the elements of ss with the first occurrence of s
+moved to index 0. The other elements keep their relative order. If ss does not contain s
+MoveToFront returns a copy of ss.
+*/
+func MoveToFront(ss []string, s string) []string {
+	moved := make([]string, 0, len(ss))
+	for i, e := range ss {
+		if e == s {
+			moved = append(moved, s)
+			moved = append(moved, ss[:i]...)
+			return append(moved, ss[i+1:]...)
+		}
+	}
+	return append(moved, ss...)
+}
+
+/*
+SplitEach returns the pieces of all the elements of ss split on sep, in order.
+Every element is split as by strings.Split, so empty fields are retained, e.g.:
+SplitEach([]string{"a,,b", "c"}, ",") returns ["a", "", "b", "c"].
+*/
+func SplitEach(ss []string, sep string) []string {
+	split := make([]string, 0, len(ss))
+	for _, s := range ss {
+		split = append(split, strings.Split(s, sep)...)
+	}
+	return split
+}
+
+/*
+SplitEachFunc returns the fields of all the elements of ss, in order. Every element is split at
+each run of runes satisfying f, as by strings.FieldsFunc, so empty fields are dropped, e.g.:
+SplitEachFunc([]string{"a, ,b", "c"}, isCommaOrSpace) returns ["a", "b", "c"].
+*/
+func SplitEachFunc(ss []string, f func(rune) bool) []string {
+	split := make([]string, 0, len(ss))
+	for _, s := range ss {
+		split = append(split, strings.FieldsFunc(s, f)...)
+	}
+	return split
+}
+
+/*
+Prepend returns a new slice containing elems followed by the elements of ss.
+*/
+func Prepend(ss []string, elems ...string) []string {
+	pre := make([]string, 0, len(elems)+len(ss))
+	pre = append(pre, elems...)
+	return append(pre, ss...)
+}
+
+/*
+ConcatUnique returns a new slice containing the elements of slices in order, with every
+element that occurs more than once, in the same or different slices, retained only at its
+first occurrence.
+*/
+func ConcatUnique(slices ...[]string) []string {
+	cat := []string{}
+	seen := make(map[string]bool)
+	for _, ss := range slices {
+		for _, s := range ss {
+			if !seen[s] {
+				seen[s] = true
+				cat = append(cat, s)
+			}
+		}
+	}
+	return cat
+}
+
+/*
+FindDuplicates returns the values that occur more than once in ss, each value once, in the
+order of their first occurrence in ss.
+*/
+func FindDuplicates(ss []string) []string {
+	count := make(map[string]int, len(ss))
+	for _, s := range ss {
+		count[s]++
+	}
+	dups := []string{}
+	for _, s := range ss {
+		if count[s] > 1 {
+			dups = append(dups, s)
+			count[s] = 0
+		}
+	}
+	return dups
+}
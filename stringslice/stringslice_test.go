@@ -39,3 +39,170 @@ func Test3(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func sameOrder(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRotate(t *testing.T) {
+	ss := []string{"a", "b", "c", "d"}
+	tests := []struct {
+		k           int
+		left, right []string
+	}{
+		{0, []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}},
+		{1, []string{"b", "c", "d", "a"}, []string{"d", "a", "b", "c"}},
+		{4, []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}},
+		{6, []string{"c", "d", "a", "b"}, []string{"c", "d", "a", "b"}},
+		{-1, []string{"d", "a", "b", "c"}, []string{"b", "c", "d", "a"}},
+		{-5, []string{"d", "a", "b", "c"}, []string{"b", "c", "d", "a"}},
+	}
+	for _, test := range tests {
+		if left := RotateLeft(ss, test.k); !sameOrder(left, test.left) {
+			t.Errorf("RotateLeft(%v, %d) = %v, expected %v", ss, test.k, left, test.left)
+		}
+		if right := RotateRight(ss, test.k); !sameOrder(right, test.right) {
+			t.Errorf("RotateRight(%v, %d) = %v, expected %v", ss, test.k, right, test.right)
+		}
+	}
+	if !sameOrder(ss, []string{"a", "b", "c", "d"}) {
+		t.Errorf("ss modified: %v", ss)
+	}
+	if len(RotateLeft(nil, 3)) != 0 || len(RotateRight([]string{}, 3)) != 0 {
+		t.Fail()
+	}
+	if one := RotateRight([]string{"a"}, 3); !sameOrder(one, []string{"a"}) {
+		t.Errorf("RotateRight([a], 3) = %v", one)
+	}
+}
+
+// chunk splits ss into slices of n elements. The last slice may be shorter.
+func chunk(ss []string, n int) (chunks [][]string) {
+	for len(ss) > n {
+		chunks = append(chunks, ss[:n])
+		ss = ss[n:]
+	}
+	return append(chunks, ss)
+}
+
+func TestFlatten(t *testing.T) {
+	ss := []string{"a", "b", "c", "d", "e"}
+	for n := 1; n <= len(ss)+1; n++ {
+		if flat := Flatten(chunk(ss, n)); !sameOrder(flat, ss) {
+			t.Errorf("Flatten(chunk(ss, %d)) = %v", n, flat)
+		}
+	}
+	if flat := Flatten([][]string{nil, {"a"}, {}, {"b", "c"}, nil}); !sameOrder(flat, []string{"a", "b", "c"}) {
+		t.Errorf("Flatten with empty slices = %v", flat)
+	}
+	if flat := Flatten(nil); flat == nil || len(flat) != 0 {
+		t.Errorf("Flatten(nil) = %v", flat)
+	}
+}
+
+func TestDiffReport(t *testing.T) {
+	onlyA, onlyB := DiffReport([]string{"a", "b", "c", "a"}, []string{"c", "d", "b", "d", "e"})
+	if !sameOrder(onlyA, []string{"a"}) || !sameOrder(onlyB, []string{"d", "e"}) {
+		t.Errorf("DiffReport = %v, %v", onlyA, onlyB)
+	}
+	onlyA, onlyB = DiffReport([]string{"a", "b", "b"}, []string{"b", "a"})
+	if len(onlyA) != 0 || len(onlyB) != 0 {
+		t.Errorf("DiffReport of equal sets = %v, %v", onlyA, onlyB)
+	}
+	onlyA, onlyB = DiffReport(nil, []string{"x"})
+	if len(onlyA) != 0 || !sameOrder(onlyB, []string{"x"}) {
+		t.Errorf("DiffReport(nil, [x]) = %v, %v", onlyA, onlyB)
+	}
+}
+
+func TestMoveToFront(t *testing.T) {
+	ss := []string{"a", "b", "c", "b", "d"}
+	tests := []struct {
+		s   string
+		exp []string
+	}{
+		{"b", []string{"b", "a", "c", "b", "d"}},
+		{"d", []string{"d", "a", "b", "c", "b"}},
+		{"a", []string{"a", "b", "c", "b", "d"}},
+		{"x", []string{"a", "b", "c", "b", "d"}},
+	}
+	for _, test := range tests {
+		if moved := MoveToFront(ss, test.s); !sameOrder(moved, test.exp) {
+			t.Errorf("MoveToFront(%v, %s) = %v, expected %v", ss, test.s, moved, test.exp)
+		}
+	}
+	if !sameOrder(ss, []string{"a", "b", "c", "b", "d"}) {
+		t.Errorf("MoveToFront modified its argument: %v", ss)
+	}
+	if moved := MoveToFront(nil, "a"); len(moved) != 0 {
+		t.Errorf("MoveToFront(nil) = %v", moved)
+	}
+}
+
+func TestSplitEach(t *testing.T) {
+	tests := []struct {
+		ss, exp []string
+	}{
+		{[]string{"a,b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"a,,b", "", "c,"}, []string{"a", "", "b", "", "c", ""}},
+		{nil, []string{}},
+	}
+	for _, test := range tests {
+		if split := SplitEach(test.ss, ","); !sameOrder(split, test.exp) {
+			t.Errorf("SplitEach(%q) = %q, expected %q", test.ss, split, test.exp)
+		}
+	}
+
+	isSep := func(r rune) bool { return r == ',' || r == ' ' }
+	split := SplitEachFunc([]string{"a, b", "", " ,c,, d "}, isSep)
+	if exp := []string{"a", "b", "c", "d"}; !sameOrder(split, exp) {
+		t.Errorf("SplitEachFunc = %q, expected %q", split, exp)
+	}
+}
+
+func TestPrepend(t *testing.T) {
+	ss := []string{"c", "d"}
+	if pre := Prepend(ss, "a", "b"); !sameOrder(pre, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Prepend = %v", pre)
+	}
+	if pre := Prepend(ss); !sameOrder(pre, ss) {
+		t.Errorf("Prepend without elements = %v", pre)
+	}
+	if pre := Prepend(nil, "a"); !sameOrder(pre, []string{"a"}) {
+		t.Errorf("Prepend to nil = %v", pre)
+	}
+}
+
+func TestConcatUnique(t *testing.T) {
+	cat := ConcatUnique([]string{"a", "b", "a"}, nil, []string{"c", "b"}, []string{"d", "a"})
+	if exp := []string{"a", "b", "c", "d"}; !sameOrder(cat, exp) {
+		t.Errorf("ConcatUnique = %v, expected %v", cat, exp)
+	}
+	if cat := ConcatUnique(); len(cat) != 0 {
+		t.Errorf("ConcatUnique() = %v", cat)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	tests := []struct {
+		ss, exp []string
+	}{
+		{[]string{"a", "b", "c"}, []string{}},
+		{nil, []string{}},
+		{[]string{"a", "b", "a"}, []string{"a"}},
+		{[]string{"c", "a", "b", "a", "c", "d", "c", "b"}, []string{"c", "a", "b"}},
+	}
+	for _, test := range tests {
+		if dups := FindDuplicates(test.ss); !sameOrder(dups, test.exp) {
+			t.Errorf("FindDuplicates(%v) = %v, expected %v", test.ss, dups, test.exp)
+		}
+	}
+}